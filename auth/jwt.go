@@ -15,18 +15,20 @@ var jwtSecret = []byte("your-super-secret-key")
 
 // ユーザー情報構造体
 type User struct {
-    UserID   string `json:"userID"`
-    Username string `json:"username"`
-    Email    string `json:"email"`
-    Role     string `json:"role"`
+    UserID   string   `json:"userID"`
+    Username string   `json:"username"`
+    Email    string   `json:"email"`
+    Role     string   `json:"role"`
+    Groups   []string `json:"groups"` // 所属グループ（チームスペースへの書き込み権限）
 }
 
 // JWTクレーム構造体
 type Claims struct {
-    UserID   string `json:"userID"`
-    Username string `json:"username"`
-    Email    string `json:"email"`
-    Role     string `json:"role"`
+    UserID   string   `json:"userID"`
+    Username string   `json:"username"`
+    Email    string   `json:"email"`
+    Role     string   `json:"role"`
+    Groups   []string `json:"groups"`
     jwt.RegisteredClaims
 }
 
@@ -37,12 +39,14 @@ var users = map[string]User{
         Username: "testuser",
         Email:    "test@example.com",
         Role:     "user",
+        Groups:   []string{"dev"},
     },
     "admin": {
         UserID:   "admin",
         Username: "admin",
         Email:    "admin@example.com",
         Role:     "admin",
+        Groups:   []string{"dev", "ops"},
     },
 }
 
@@ -63,6 +67,7 @@ func GenerateToken(user User) (string, error) {
         Username: user.Username,
         Email:    user.Email,
         Role:     user.Role,
+        Groups:   user.Groups,
         RegisteredClaims: jwt.RegisteredClaims{
             ExpiresAt: jwt.NewNumericDate(expirationTime),
             IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -167,6 +172,7 @@ func JWTMiddleware(next http.HandlerFunc) http.HandlerFunc {
         // リクエストコンテキストにユーザー情報を追加
         r.Header.Set("X-User-ID", claims.UserID)
         r.Header.Set("X-User-Role", claims.Role)
+        r.Header.Set("X-User-Groups", strings.Join(claims.Groups, ","))
         
         // 次のハンドラーを実行
         next.ServeHTTP(w, r)
@@ -185,6 +191,25 @@ func AdminOnlyMiddleware(next http.HandlerFunc) http.HandlerFunc {
     })
 }
 
+// リクエストヘッダーから所属グループ一覧を取得（JWTMiddleware通過後のみ有効）
+func GetUserGroups(r *http.Request) []string {
+    header := r.Header.Get("X-User-Groups")
+    if header == "" {
+        return nil
+    }
+    return strings.Split(header, ",")
+}
+
+// ユーザーが指定グループに所属しているか確認
+func IsGroupMember(r *http.Request, group string) bool {
+    for _, g := range GetUserGroups(r) {
+        if g == group {
+            return true
+        }
+    }
+    return false
+}
+
 // リフレッシュトークン生成
 func GenerateRefreshToken(userID string) (string, error) {
     expirationTime := time.Now().Add(7 * 24 * time.Hour) // 7日間有効
@@ -1,7 +1,12 @@
 package main
 
 import (
+    "context"
     "log"
+    "os"
+    "os/signal"
+    "syscall"
+    "time"
     "github.com/USlayout/go-minio/network"
     "github.com/USlayout/go-minio/storage"
 )
@@ -14,9 +19,64 @@ func main() {
         log.Fatalf("MinIO init error: %v", err)
     }
 
+    // 重複排除の対応表の読み込みと未参照blobの定期削除
+    err = storage.StartDedupCollector()
+    if err != nil {
+        log.Fatalf("Dedup init error: %v", err)
+    }
+
+    // 使用量の集計とクォータ設定の読み込み
+    err = storage.StartQuotaReconciler()
+    if err != nil {
+        log.Fatalf("Quota init error: %v", err)
+    }
+
+    // 検索用メタデータインデックスの構築
+    err = storage.StartSearchIndexer()
+    if err != nil {
+        log.Fatalf("Search index init error: %v", err)
+    }
+
+    // 全文検索インデックスの読み込みと本文抽出ワーカーの起動
+    err = storage.StartFullTextIndexer()
+    if err != nil {
+        log.Fatalf("Full-text index init error: %v", err)
+    }
+
+    // 画像のサムネイル生成ワーカーの起動
+    storage.StartThumbnailer()
+
+    // 最近使ったファイル・アクティビティの定期保存
+    storage.StartUserDataFlusher()
+
+    // 期限切れゴミ箱エントリの自動削除
+    storage.StartTrashPurger()
+
+    // 中断したアップロードの一時オブジェクトの削除
+    storage.StartUploadStagingSweeper()
+
+    // SIGINT/SIGTERM を受け取ったら処理中のリクエストを終えてから停止
+    stopped := make(chan struct{})
+    go func() {
+        sig := make(chan os.Signal, 1)
+        signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+        <-sig
+
+        ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+        defer cancel()
+        if err := network.ShutdownServer(ctx); err != nil {
+            log.Printf("Server shutdown error: %v", err)
+        }
+        close(stopped)
+    }()
+
     // HTTPサーバ起動
     err = network.StartServer(":8080")
     if err != nil {
         log.Fatalf("Server error: %v", err)
     }
+    <-stopped
+
+    // 未保存の最近使ったファイル・アクティビティを書き出してから終了
+    storage.FlushUserData()
 }
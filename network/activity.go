@@ -0,0 +1,244 @@
+package network
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/USlayout/go-minio/auth"
+	"github.com/USlayout/go-minio/storage"
+)
+
+// フィードの既定件数と上限
+const (
+	defaultFeedLimit = 50
+	maxFeedLimit     = 500
+)
+
+// スペースでの操作をアクティビティに記録
+func recordActivity(root, userID, action, path, name string, isFolder bool, destination string) {
+	itemType := "file"
+	if isFolder {
+		itemType = "folder"
+	}
+	storage.RecordActivity(root, storage.ActivityEvent{
+		Path:        path,
+		Name:        name,
+		Type:        itemType,
+		Action:      action,
+		Actor:       userID,
+		Destination: destination,
+	})
+}
+
+// 個人スペースのファイルを最近使ったファイルに記録
+func recordRecent(userID, action, path, name string) {
+	storage.RecordRecent(userID, storage.RecentItem{
+		Path:   path,
+		Name:   name,
+		Action: action,
+	})
+}
+
+// スペース内のパス（"docs/report.pdf"）を組み立てる
+func spacePath(path, name string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return name
+	}
+	return path + "/" + name
+}
+
+// スペース内のパスをフォルダとファイル名に分割（"docs/a/report.pdf" → "docs/a", "report.pdf"）
+func splitSpacePath(p string) (string, string) {
+	p = strings.Trim(p, "/")
+	if i := strings.LastIndex(p, "/"); i >= 0 {
+		return p[:i], p[i+1:]
+	}
+	return "", p
+}
+
+// limit パラメータを解析（未指定の場合は既定値）
+func parseFeedLimit(r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultFeedLimit, true
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxFeedLimit {
+		return 0, false
+	}
+	return limit, true
+}
+
+// お気に入りハンドラー（GET: 一覧、POST: 追加、DELETE: 削除）
+func handleFavorites(w http.ResponseWriter, r *http.Request) {
+	// CORS設定
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 認証済みユーザーIDを取得
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		http.Error(w, "User ID not found in token", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		// 脱退したグループのチームスペースのお気に入りは返さない
+		favorites, err := storage.ListFavorites(userID, func(space string) string {
+			if space == "" {
+				return userID
+			}
+			if !auth.IsGroupMember(r, space) {
+				return ""
+			}
+			return teamSpacePrefix + space
+		})
+		if err != nil {
+			http.Error(w, "Failed to list favorites: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"favorites": favorites,
+		})
+		return
+	}
+
+	space := r.FormValue("space")
+	path := r.FormValue("path")
+	filename := r.FormValue("filename")
+	folder := r.FormValue("folder")
+
+	// 対象（ファイルまたはフォルダのどちらか一方）
+	favorite := storage.Favorite{Space: space, Path: path, Name: filename, Type: "file"}
+	if folder != "" {
+		favorite.Name = folder
+		favorite.Type = "folder"
+	}
+	if (filename == "") == (folder == "") {
+		http.Error(w, "Specify either filename or folder parameter", http.StatusBadRequest)
+		return
+	}
+	if err := validatePath(path); err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateName(favorite.Name); err != nil {
+		http.Error(w, "Invalid name: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := resolveSpaceRoot(r, userID, space); err != nil {
+		http.Error(w, "Invalid space: "+err.Error(), spaceErrorStatus(err))
+		return
+	}
+
+	var err error
+	if r.Method == http.MethodPost {
+		err = storage.AddFavorite(userID, favorite)
+	} else {
+		err = storage.RemoveFavorite(userID, favorite)
+	}
+	if err != nil {
+		http.Error(w, "Favorite update failed: "+err.Error(), storageErrorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"favorite": favorite,
+		"starred":  r.Method == http.MethodPost,
+	})
+}
+
+// 最近使ったファイルハンドラー（アップロード・ダウンロードしたファイル）
+func handleRecent(w http.ResponseWriter, r *http.Request) {
+	// CORS設定
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 認証済みユーザーIDを取得
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		http.Error(w, "User ID not found in token", http.StatusBadRequest)
+		return
+	}
+
+	limit, ok := parseFeedLimit(r)
+	if !ok {
+		http.Error(w, "Invalid parameter: limit must be between 1 and "+strconv.Itoa(maxFeedLimit), http.StatusBadRequest)
+		return
+	}
+
+	items, err := storage.ListRecent(userID, limit)
+	if err != nil {
+		http.Error(w, "Failed to list recent files: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"recent": items,
+	})
+}
+
+// アクティビティフィードハンドラー（個人スペースと所属グループのチームスペースでの操作）
+func handleActivity(w http.ResponseWriter, r *http.Request) {
+	// CORS設定
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 認証済みユーザーIDを取得
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		http.Error(w, "User ID not found in token", http.StatusBadRequest)
+		return
+	}
+
+	roots, err := searchRoots(r, userID)
+	if err != nil {
+		http.Error(w, "Invalid space: "+err.Error(), spaceErrorStatus(err))
+		return
+	}
+
+	limit, ok := parseFeedLimit(r)
+	if !ok {
+		http.Error(w, "Invalid parameter: limit must be between 1 and "+strconv.Itoa(maxFeedLimit), http.StatusBadRequest)
+		return
+	}
+
+	// before に前ページ最後の at を指定して続きを取得
+	var before time.Time
+	if value := r.URL.Query().Get("before"); value != "" {
+		before, err = time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			http.Error(w, "Invalid parameter: before must be RFC3339", http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, err := storage.ListActivity(roots, before, limit)
+	if err != nil {
+		http.Error(w, "Failed to list activity: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"activity": events,
+	})
+}
@@ -0,0 +1,307 @@
+package network
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/USlayout/go-minio/storage"
+)
+
+// 一括ダウンロードの形式
+const (
+	archiveZip   = "zip"
+	archiveTarGz = "tar.gz"
+)
+
+// 含められなかった項目の一覧を格納するアーカイブ内のファイル名
+const archiveSkippedName = "_skipped.txt"
+
+// アーカイブに含める1件
+type archiveItem struct {
+	key      string // オブジェクトキー（フォルダの場合は空）
+	name     string // アーカイブ内のパス（フォルダは末尾 "/"）
+	isDir    bool
+	modified time.Time
+}
+
+// アーカイブに含められなかった項目
+type archiveSkip struct {
+	name   string
+	reason string
+}
+
+// アーカイブの書き出し先（ZIP / tar.gz）
+type archiveWriter interface {
+	addDir(name string, modified time.Time) error
+	addFile(name string, info *storage.FileInfo, data io.Reader) error
+	Close() error
+}
+
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchive) addDir(name string, modified time.Time) error {
+	_, err := a.zw.CreateHeader(&zip.FileHeader{Name: name, Modified: modified})
+	return err
+}
+
+// 4GiBを超えるファイル・65535件を超えるエントリは自動的にZIP64形式で書き出される
+func (a *zipArchive) addFile(name string, info *storage.FileInfo, data io.Reader) error {
+	header := &zip.FileHeader{Name: name, Modified: info.LastModified, Method: zip.Store}
+	if isDeflatable(info.ContentType) {
+		header.Method = zip.Deflate
+	}
+	w, err := a.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, data)
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.zw.Close()
+}
+
+type tarGzArchive struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (a *tarGzArchive) addDir(name string, modified time.Time) error {
+	return a.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: 0755, ModTime: modified})
+}
+
+func (a *tarGzArchive) addFile(name string, info *storage.FileInfo, data io.Reader) error {
+	err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     info.Size,
+		ModTime:  info.LastModified,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(a.tw, data, info.Size)
+	return err
+}
+
+func (a *tarGzArchive) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+// 圧縮して効果のあるコンテンツタイプか（画像・動画・圧縮済みの形式は無圧縮で格納する）
+func isDeflatable(contentType string) bool {
+	mediaType := baseMediaType(contentType)
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "json") ||
+		strings.HasSuffix(mediaType, "xml") ||
+		mediaType == "application/javascript" ||
+		mediaType == "application/pdf"
+}
+
+// フォルダ・ファイルをまとめてZIPまたはtar.gzでダウンロードするハンドラー
+// 一時ファイルを作らず、1ファイルずつ読み出しながら書き出す
+func handleDownloadArchive(w http.ResponseWriter, r *http.Request) {
+	// CORS設定
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 認証済みユーザーIDを取得
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		http.Error(w, "User ID not found in token", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	dir := strings.Trim(r.FormValue("path"), "/")
+	names := r.Form["item"]
+	format := r.FormValue("format")
+	if format == "" {
+		format = archiveZip
+	}
+	if format != archiveZip && format != archiveTarGz {
+		http.Error(w, "Invalid parameter: format must be zip or tar.gz", http.StatusBadRequest)
+		return
+	}
+
+	if err := validatePath(dir); err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, name := range names {
+		if strings.Trim(name, "/") == "" || validatePath(name) != nil {
+			http.Error(w, "Invalid item: "+name, http.StatusBadRequest)
+			return
+		}
+	}
+
+	root, err := resolveSpaceRoot(r, userID, r.FormValue("space"))
+	if err != nil {
+		http.Error(w, "Invalid space: "+err.Error(), spaceErrorStatus(err))
+		return
+	}
+
+	items, skipped, err := collectArchiveItems(root, dir, names)
+	if err != nil {
+		http.Error(w, "Download failed: "+err.Error(), storageErrorStatus(err))
+		return
+	}
+	if len(items) == 0 {
+		http.Error(w, "Download failed: nothing to download", http.StatusNotFound)
+		return
+	}
+	if int64(len(items)) > storage.ArchiveMaxFiles {
+		http.Error(w, fmt.Sprintf("Download failed: %v (limit %d)", storage.ErrTooManyFiles, storage.ArchiveMaxFiles),
+			http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// アーカイブ名はフォルダ名（指定がなければ "download"）
+	archiveName := path.Base(dir)
+	if dir == "" {
+		archiveName = "download"
+	}
+	archiveName += "." + format
+
+	var archive archiveWriter
+	if format == archiveZip {
+		w.Header().Set("Content-Type", "application/zip")
+		archive = &zipArchive{zw: zip.NewWriter(w)}
+	} else {
+		w.Header().Set("Content-Type", "application/gzip")
+		gz := gzip.NewWriter(w)
+		archive = &tarGzArchive{gz: gz, tw: tar.NewWriter(gz)}
+	}
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", archiveName))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Trailer", "X-Archive-Skipped")
+
+	// 開けなかったファイルは飛ばして続ける（書き出し途中の失敗は応答を打ち切る）
+	for _, item := range items {
+		if item.isDir {
+			if err := archive.addDir(item.name, item.modified); err != nil {
+				log.Printf("Error writing archive: %v", err)
+				return
+			}
+			continue
+		}
+		reader, info, err := storage.OpenFile(item.key)
+		if err != nil {
+			skipped = append(skipped, archiveSkip{name: item.name, reason: err.Error()})
+			continue
+		}
+		err = archive.addFile(item.name, info, reader)
+		reader.Close()
+		if err != nil {
+			log.Printf("Error writing archive %s: %v", item.key, err)
+			return
+		}
+	}
+
+	if len(skipped) > 0 {
+		var report strings.Builder
+		for _, skip := range skipped {
+			fmt.Fprintf(&report, "%s: %s\n", skip.name, skip.reason)
+		}
+		info := &storage.FileInfo{Size: int64(report.Len()), LastModified: time.Now(), ContentType: "text/plain"}
+		if err := archive.addFile(archiveSkippedName, info, strings.NewReader(report.String())); err != nil {
+			log.Printf("Error writing archive: %v", err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("Error writing archive: %v", err)
+		return
+	}
+	w.Header().Set("X-Archive-Skipped", strconv.Itoa(len(skipped)))
+}
+
+// アーカイブに含めるファイル・フォルダを列挙
+// names が空の場合は dir 配下すべてをフォルダ名の下に、それ以外は dir からの相対パスで格納する
+func collectArchiveItems(root, dir string, names []string) ([]archiveItem, []archiveSkip, error) {
+	base := root
+	if dir != "" {
+		base = root + "/" + dir
+	}
+
+	var items []archiveItem
+	var skipped []archiveSkip
+
+	if len(names) == 0 {
+		entryPrefix := ""
+		if dir != "" {
+			entryPrefix = path.Base(dir) + "/"
+		}
+		folderItems, err := folderArchiveItems(base, entryPrefix)
+		return folderItems, nil, err
+	}
+
+	for _, name := range names {
+		name = strings.Trim(name, "/")
+		key := base + "/" + name
+		exists, err := storage.FileExists(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if exists {
+			items = append(items, archiveItem{key: key, name: name})
+			continue
+		}
+
+		folderItems, err := folderArchiveItems(key, name+"/")
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(folderItems) == 0 {
+			skipped = append(skipped, archiveSkip{name: name, reason: "not found"})
+			continue
+		}
+		items = append(items, folderItems...)
+	}
+	return items, skipped, nil
+}
+
+// フォルダ配下のファイルと空フォルダを列挙（アーカイブ内では entryPrefix の下に置く）
+func folderArchiveItems(prefix, entryPrefix string) ([]archiveItem, error) {
+	objects, err := storage.ListFolderObjects(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]archiveItem, 0, len(objects))
+	for _, object := range objects {
+		rel := strings.TrimPrefix(object.Name, strings.TrimSuffix(prefix, "/")+"/")
+		if path.Base(rel) == ".keep" {
+			// 空フォルダはフォルダのエントリとして格納（ルートの .keep は不要）
+			if name := entryPrefix + strings.TrimSuffix(rel, ".keep"); name != "" {
+				items = append(items, archiveItem{name: name, isDir: true, modified: object.LastModified})
+			}
+			continue
+		}
+		items = append(items, archiveItem{key: object.Name, name: entryPrefix + rel})
+	}
+	return items, nil
+}
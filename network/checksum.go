@@ -0,0 +1,36 @@
+package network
+
+import (
+	"mime/multipart"
+	"net/http"
+
+	"github.com/USlayout/go-minio/storage"
+)
+
+// アップロードファイルのチェックサムを取得
+// multipartの各パートのヘッダーを優先し、単一ファイルのアップロードではリクエストヘッダーも参照する
+func uploadChecksums(r *http.Request, header *multipart.FileHeader, useRequestHeader bool) (storage.Checksums, error) {
+	contentMD5 := header.Header.Get("Content-MD5")
+	sha256 := header.Header.Get("X-Checksum-Sha256")
+	if useRequestHeader {
+		if contentMD5 == "" {
+			contentMD5 = r.Header.Get("Content-MD5")
+		}
+		if sha256 == "" {
+			sha256 = r.Header.Get("X-Checksum-Sha256")
+		}
+	}
+	return storage.ParseChecksums(contentMD5, sha256)
+}
+
+// 保存済みのチェックサムを Repr-Digest（RFC 9530）と Digest（RFC 3230）ヘッダーに設定
+func setDigestHeaders(w http.ResponseWriter, sums *storage.Checksums) {
+	if sums == nil {
+		return
+	}
+	if digest := sums.SHA256Base64(); digest != "" {
+		w.Header().Set("Repr-Digest", "sha-256=:"+digest+":")
+		w.Header().Set("Digest", "SHA-256="+digest)
+		w.Header().Set("Access-Control-Expose-Headers", "Repr-Digest, Digest")
+	}
+}
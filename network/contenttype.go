@@ -0,0 +1,53 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+
+	"github.com/USlayout/go-minio/storage"
+)
+
+// アップロードファイルのコンテンツタイプを判定（multipartヘッダー・拡張子・先頭バイト）
+func detectUploadContentType(file multipart.File, header *multipart.FileHeader) (string, error) {
+	return storage.SniffContentType(header.Filename, header.Header.Get("Content-Type"), file)
+}
+
+// コンテンツタイプ補完ジョブ（GET: 進捗取得、POST: 開始）
+func handleContentTypeBackfill(w http.ResponseWriter, r *http.Request) {
+	// CORS設定
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(storage.GetBackfillStatus())
+
+	case http.MethodPost:
+		dryRun := false
+		if value := r.FormValue("dryRun"); value != "" {
+			var err error
+			if dryRun, err = strconv.ParseBool(value); err != nil {
+				http.Error(w, "Invalid parameter: dryRun must be true or false", http.StatusBadRequest)
+				return
+			}
+		}
+
+		status, err := storage.StartContentTypeBackfill(dryRun)
+		if err != nil {
+			code := http.StatusInternalServerError
+			if errors.Is(err, storage.ErrBackfillRunning) {
+				code = http.StatusConflict
+			}
+			http.Error(w, "Backfill not started: "+err.Error(), code)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(status)
+
+	default:
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+	}
+}
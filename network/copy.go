@@ -0,0 +1,124 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/USlayout/go-minio/storage"
+)
+
+// ファイル/フォルダ複製ハンドラー（サーバーサイドコピー）
+func handleCopy(w http.ResponseWriter, r *http.Request) {
+	// CORS設定
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 認証済みユーザーIDを取得
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		http.Error(w, "User ID not found in token", http.StatusBadRequest)
+		return
+	}
+
+	path := r.FormValue("path")
+	filename := r.FormValue("filename")
+	folder := r.FormValue("folder")
+	dest := r.FormValue("dest")
+	newName := r.FormValue("newName")
+	overwrite, _ := strconv.ParseBool(r.FormValue("overwrite"))
+
+	// 複製対象（ファイルまたはフォルダのどちらか一方）
+	name := filename
+	isFolder := false
+	if folder != "" {
+		name = folder
+		isFolder = true
+	}
+	if (filename == "") == (folder == "") {
+		http.Error(w, "Specify either filename or folder parameter", http.StatusBadRequest)
+		return
+	}
+	if newName == "" {
+		newName = name
+	}
+
+	if err := validatePath(path); err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validatePath(dest); err != nil {
+		http.Error(w, "Invalid dest: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateName(name); err != nil {
+		http.Error(w, "Invalid source name: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateName(newName); err != nil {
+		http.Error(w, "Invalid newName: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// コピー元・コピー先のスペース（空は個人スペース、グループ名はチームスペース）
+	srcRoot, err := resolveSpaceRoot(r, userID, r.FormValue("space"))
+	if err != nil {
+		http.Error(w, "Invalid space: "+err.Error(), spaceErrorStatus(err))
+		return
+	}
+	dstRoot, err := resolveSpaceRoot(r, userID, r.FormValue("destSpace"))
+	if err != nil {
+		http.Error(w, "Invalid destSpace: "+err.Error(), spaceErrorStatus(err))
+		return
+	}
+
+	srcKey := buildObjectKey(srcRoot, path, name)
+	dstKey := buildObjectKey(dstRoot, dest, newName)
+	if err := validateObjectKey(dstKey); err != nil {
+		http.Error(w, "Invalid destination: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	// ファイルの場合は単一オブジェクトを複製
+	if !isFolder {
+		err := storage.CopyFile(srcKey, dstKey, overwrite)
+		if err != nil {
+			http.Error(w, "Copy failed: "+err.Error(), storageErrorStatus(err))
+			return
+		}
+		recordActivity(dstRoot, userID, "copy", dest, newName, false, "")
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"source":      srcKey,
+			"destination": dstKey,
+			"type":        "file",
+		})
+		return
+	}
+
+	result, err := storage.CopyFolder(srcKey, dstKey, overwrite)
+	if err != nil {
+		http.Error(w, "Copy failed: "+err.Error(), storageErrorStatus(err))
+		return
+	}
+	if result.Copied > 0 {
+		recordActivity(dstRoot, userID, "copy", dest, newName, true, "")
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+// スペース解決エラーをHTTPステータスに変換
+func spaceErrorStatus(err error) int {
+	if errors.Is(err, errSpaceForbidden) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
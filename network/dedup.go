@@ -0,0 +1,41 @@
+package network
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/USlayout/go-minio/storage"
+)
+
+// 重複排除の統計ハンドラー
+func handleDedupStats(w http.ResponseWriter, r *http.Request) {
+	// CORS設定
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	json.NewEncoder(w).Encode(storage.GetDedupStats())
+}
+
+// 参照されなくなったblobを即時に削除するハンドラー
+func handleDedupGC(w http.ResponseWriter, r *http.Request) {
+	// CORS設定
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	result, err := storage.CollectGarbageBlobs()
+	if err != nil {
+		http.Error(w, "Garbage collection failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,137 @@
+package network
+
+import (
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+)
+
+// ブラウザ内でそのまま表示してよいコンテンツタイプ
+var inlineContentTypes = map[string]bool{
+	"application/pdf": true,
+	"text/plain":      true,
+	"text/csv":        true,
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"image/bmp":       true,
+	"audio/mpeg":      true,
+	"audio/ogg":       true,
+	"audio/wav":       true,
+	"video/mp4":       true,
+	"video/webm":      true,
+	"video/ogg":       true,
+}
+
+// スクリプトを実行し得るため、常に添付ファイルとして返すコンテンツタイプ（Stored XSS対策）
+var unsafeContentTypes = map[string]bool{
+	"text/html":             true,
+	"application/xhtml+xml": true,
+	"image/svg+xml":         true,
+	"text/xml":              true,
+	"application/xml":       true,
+}
+
+// ダウンロード時のContent-Typeを決定（保存値がなければ拡張子から推測）
+func resolveContentType(storedType, filename string) string {
+	contentType := baseMediaType(storedType)
+	if contentType == "" || contentType == "application/octet-stream" {
+		if byExt := baseMediaType(mime.TypeByExtension(path.Ext(filename))); byExt != "" {
+			return byExt
+		}
+	}
+	if contentType == "" {
+		return "application/octet-stream"
+	}
+	return contentType
+}
+
+// パラメータ（charset等）を除いたメディアタイプを小文字で返す
+func baseMediaType(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(mediaType)
+}
+
+// HTML/SVG等のスクリプト実行可能なファイルか判定（保存値と拡張子の両方を確認）
+func isUnsafeContent(contentType, filename string) bool {
+	if unsafeContentTypes[baseMediaType(contentType)] {
+		return true
+	}
+	return unsafeContentTypes[baseMediaType(mime.TypeByExtension(path.Ext(filename)))]
+}
+
+// インライン表示の可否を判定し、Content-Dispositionの種類を返す
+func dispositionType(wantInline bool, contentType, filename string) string {
+	if !wantInline || isUnsafeContent(contentType, filename) {
+		return "attachment"
+	}
+	mediaType := baseMediaType(contentType)
+	if inlineContentTypes[mediaType] {
+		return "inline"
+	}
+	return "attachment"
+}
+
+// RFC 6266 / RFC 5987 形式のContent-Dispositionヘッダー値を構築
+// 非ASCIIのファイル名は filename（ASCII代替名）と filename*（UTF-8パーセントエンコード）を併記
+func contentDisposition(dispType, filename string) string {
+	name := path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if name == "." || name == "/" {
+		name = "download"
+	}
+
+	fallback := asciiFilename(name)
+	if fallback == name {
+		return fmt.Sprintf("%s; filename=\"%s\"", dispType, fallback)
+	}
+	return fmt.Sprintf("%s; filename=\"%s\"; filename*=UTF-8''%s", dispType, fallback, encodeRFC5987(name))
+}
+
+// 非ASCII文字や引用符を置き換えた、古いクライアント向けのファイル名を生成
+func asciiFilename(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r < 0x20 || r == 0x7f || r > 0x7e:
+			b.WriteByte('_')
+		case r == '"' || r == '\\':
+			b.WriteByte('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// RFC 5987 の attr-char 以外をパーセントエンコード
+func encodeRFC5987(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isAttrChar(c) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0x0f])
+	}
+	return b.String()
+}
+
+func isAttrChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
@@ -0,0 +1,50 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/USlayout/go-minio/storage"
+)
+
+// 暗号化フォルダの鍵の入れ替えジョブ（GET: 進捗取得、POST: 開始）
+func handleEnvelopeRewrap(w http.ResponseWriter, r *http.Request) {
+	// CORS設定
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(storage.GetRewrapStatus())
+
+	case http.MethodPost:
+		dryRun := false
+		if value := r.FormValue("dryRun"); value != "" {
+			var err error
+			if dryRun, err = strconv.ParseBool(value); err != nil {
+				http.Error(w, "Invalid parameter: dryRun must be true or false", http.StatusBadRequest)
+				return
+			}
+		}
+
+		status, err := storage.StartEnvelopeRewrap(dryRun)
+		if err != nil {
+			code := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, storage.ErrRewrapRunning):
+				code = http.StatusConflict
+			case errors.Is(err, storage.ErrEnvelopeKeyUnavailable):
+				code = http.StatusBadRequest
+			}
+			http.Error(w, "Rotation not started: "+err.Error(), code)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(status)
+
+	default:
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,390 @@
+package network
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/USlayout/go-minio/storage"
+)
+
+// このサイズ以下のエントリはメモリに読み込んでから保存する（内容からコンテンツタイプを判定できる）
+const extractBufferSize = 8 << 20
+
+// 圧縮率を確認する最小サイズ（小さなファイルは圧縮率が高くても問題にならない）
+const extractRatioMinSize = 1 << 20
+
+// 展開結果の状態
+const (
+	extractStatusExtracted = "extracted"
+	extractStatusSkipped   = "skipped"
+	extractStatusFailed    = "failed"
+)
+
+// アーカイブ内の1エントリ
+type archiveEntry struct {
+	name       string
+	size       int64 // 展開後のサイズ（アーカイブに記録された値）
+	compressed int64 // 圧縮後のサイズ（ZIPのみ、不明な場合は -1）
+	isDir      bool
+	regular    bool // 通常のファイルまたはフォルダか（シンボリックリンク等は展開しない）
+}
+
+// 事前確認の結果
+type extractScan struct {
+	files   int64
+	total   int64
+	parents map[string]bool // ファイルを含むフォルダ（空フォルダ以外は .keep を作らない）
+}
+
+// エントリごとの展開結果
+type extractEntryResult struct {
+	Name   string `json:"name"`
+	Key    string `json:"key,omitempty"`
+	Size   int64  `json:"size"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// 展開結果のレポート
+type extractResult struct {
+	Archive     string               `json:"archive"`
+	Destination string               `json:"destination"`
+	Total       int                  `json:"total"`
+	Extracted   int                  `json:"extracted"`
+	Skipped     int                  `json:"skipped"`
+	Failed      int                  `json:"failed"`
+	TotalSize   int64                `json:"totalSize"`
+	Entries     []extractEntryResult `json:"entries"`
+}
+
+// ファイル名の拡張子からアーカイブの形式を判定（対応していない場合は空）
+func extractFormat(filename string) string {
+	name := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	}
+	return ""
+}
+
+// 読み取ったバイト数を数えるReader
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// 展開後のデータを読みながら圧縮率を確認し、上限を超えた時点で読み取りを止めるReader（tar.gz用）
+// tar.gz はエントリごとの圧縮後のサイズが分からないため、展開済みの量と読み取った圧縮データの量で比べる
+type ratioLimitReader struct {
+	r          io.Reader
+	compressed *countingReader
+	read       int64
+}
+
+func (l *ratioLimitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > extractRatioMinSize && l.read/max(l.compressed.n, 1) > storage.ExtractMaxRatio {
+		return n, fmt.Errorf("%w: compression ratio exceeds %d", storage.ErrArchiveLimit, storage.ExtractMaxRatio)
+	}
+	return n, err
+}
+
+// アーカイブのエントリを順に処理（open はそのエントリの処理中のみ有効）
+func walkArchive(format string, file multipart.File, size int64, fn func(entry archiveEntry, open func() (io.ReadCloser, error)) error) error {
+	if format == "zip" {
+		zr, err := zip.NewReader(file, size)
+		if err != nil {
+			return fmt.Errorf("%w: %v", storage.ErrUnsupportedArchive, err)
+		}
+		for _, f := range zr.File {
+			mode := f.Mode()
+			entry := archiveEntry{
+				name:       f.Name,
+				size:       int64(f.UncompressedSize64),
+				compressed: int64(f.CompressedSize64),
+				isDir:      mode.IsDir(),
+				regular:    mode.IsRegular() || mode.IsDir(),
+			}
+			if err := fn(entry, f.Open); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	var src io.Reader = file
+	if format == "tar.gz" {
+		compressed := &countingReader{r: file}
+		gz, err := gzip.NewReader(compressed)
+		if err != nil {
+			return fmt.Errorf("%w: %v", storage.ErrUnsupportedArchive, err)
+		}
+		defer gz.Close()
+		src = &ratioLimitReader{r: gz, compressed: compressed}
+	}
+	tr := tar.NewReader(src)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if errors.Is(err, storage.ErrArchiveLimit) {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", storage.ErrUnsupportedArchive, err)
+		}
+		entry := archiveEntry{
+			name:       header.Name,
+			size:       header.Size,
+			compressed: -1,
+			isDir:      header.Typeflag == tar.TypeDir,
+			regular:    header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeDir,
+		}
+		if err := fn(entry, func() (io.ReadCloser, error) { return io.NopCloser(tr), nil }); err != nil {
+			return err
+		}
+	}
+}
+
+// 展開する前にエントリ数・合計サイズ・圧縮率を確認（上限を超える場合は何も展開しない）
+func scanArchive(format string, file multipart.File, size int64) (*extractScan, error) {
+	scan := &extractScan{parents: map[string]bool{}}
+	var entries int64
+	err := walkArchive(format, file, size, func(entry archiveEntry, _ func() (io.ReadCloser, error)) error {
+		entries++
+		if entries > storage.ExtractMaxEntries {
+			return fmt.Errorf("%w: more than %d entries", storage.ErrArchiveLimit, storage.ExtractMaxEntries)
+		}
+		if !entry.regular || entry.isDir {
+			return nil
+		}
+		if entry.size < 0 {
+			return fmt.Errorf("%w: invalid size for %s", storage.ErrArchiveLimit, entry.name)
+		}
+		scan.files++
+		scan.total += entry.size
+		if scan.total > storage.ExtractMaxSize {
+			return fmt.Errorf("%w: total size exceeds %d bytes", storage.ErrArchiveLimit, storage.ExtractMaxSize)
+		}
+		if entry.compressed >= 0 && entry.size > extractRatioMinSize &&
+			entry.size/max(entry.compressed, 1) > storage.ExtractMaxRatio {
+			return fmt.Errorf("%w: compression ratio of %s exceeds %d", storage.ErrArchiveLimit, entry.name, storage.ExtractMaxRatio)
+		}
+		if name, ok := extractEntryName(entry.name); ok {
+			for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+				scan.parents[dir] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return scan, nil
+}
+
+// エントリ名を展開先からの相対パスに変換（先頭の "./" は取り除く）
+// 絶対パス・".."・バックスラッシュを含む名前など展開先の外を指すものは false（zip slip対策）
+func extractEntryName(name string) (string, bool) {
+	if strings.HasPrefix(name, "/") || strings.Contains(name, "\\") {
+		return "", false
+	}
+	for strings.HasPrefix(name, "./") {
+		name = name[2:]
+	}
+	name = strings.TrimSuffix(name, "/")
+	if name == "" || validatePath(name) != nil {
+		return "", false
+	}
+	return name, true
+}
+
+// アップロードされたアーカイブを展開して個別のファイルとして保存するハンドラー（/upload の extract=true）
+func handleExtractUpload(w http.ResponseWriter, r *http.Request, userID, virtualPath string, file multipart.File, header *multipart.FileHeader, opts storage.UploadOptions) {
+	format := extractFormat(header.Filename)
+	if format == "" {
+		http.Error(w, "Invalid archive: only .zip, .tar, .tar.gz and .tgz can be extracted", http.StatusBadRequest)
+		return
+	}
+	dir := strings.Trim(virtualPath, "/")
+	if err := validatePath(dir); err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	overwrite := r.FormValue("overwrite") == "true"
+
+	// アーカイブ自体のチェックサム（任意、一致しない場合は展開しない）
+	sums, err := uploadChecksums(r, header, true)
+	if err != nil {
+		http.Error(w, "Invalid checksum: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := storage.VerifyChecksums(file, sums); err != nil {
+		http.Error(w, "Extract failed: "+err.Error(), storageErrorStatus(err))
+		return
+	}
+
+	scan, err := scanArchive(format, file, header.Size)
+	if err != nil {
+		http.Error(w, "Extract failed: "+err.Error(), extractErrorStatus(err))
+		return
+	}
+	if err := storage.CheckQuota(userID+"/", scan.total, scan.files); err != nil {
+		http.Error(w, "Extract rejected: "+err.Error(), storageErrorStatus(err))
+		return
+	}
+
+	result := extractResult{Archive: header.Filename, Destination: dir, Entries: []extractEntryResult{}}
+	err = walkArchive(format, file, header.Size, func(entry archiveEntry, open func() (io.ReadCloser, error)) error {
+		// 展開先そのものを指すエントリ（"./" など）は結果に含めない
+		if entry.isDir && (entry.name == "./" || entry.name == ".") {
+			return nil
+		}
+		item := extractEntry(userID, dir, entry, open, scan, overwrite, opts)
+		if item.Status == "" {
+			return nil
+		}
+		result.Entries = append(result.Entries, item)
+		switch item.Status {
+		case extractStatusExtracted:
+			result.Extracted++
+			result.TotalSize += item.Size
+		case extractStatusSkipped:
+			result.Skipped++
+		default:
+			result.Failed++
+		}
+		return nil
+	})
+	result.Total = len(result.Entries)
+	if err != nil {
+		// 途中でアーカイブが読めなくなった場合も、展開済みのエントリは結果として返す
+		result.Entries = append(result.Entries, extractEntryResult{Status: extractStatusFailed, Error: err.Error()})
+		result.Failed++
+	}
+	if result.Extracted > 0 {
+		recordActivity(userID, userID, "extract", virtualPath, header.Filename, false, dir)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// 1エントリを展開（結果に含めないエントリは Status が空）
+func extractEntry(userID, dir string, entry archiveEntry, open func() (io.ReadCloser, error), scan *extractScan, overwrite bool, opts storage.UploadOptions) extractEntryResult {
+	item := extractEntryResult{Name: entry.name}
+	name, ok := extractEntryName(entry.name)
+	if !ok {
+		item.Status, item.Error = extractStatusFailed, "invalid entry name"
+		return item
+	}
+	if !entry.regular {
+		item.Status, item.Error = extractStatusSkipped, "unsupported entry type"
+		return item
+	}
+
+	if entry.isDir {
+		// ファイルを含むフォルダはファイルの保存で作られるため、空フォルダのみ .keep を作る
+		if scan.parents[name] {
+			return extractEntryResult{}
+		}
+		item.Key = buildObjectKey(userID, dir, name+"/.keep")
+		if err := storage.SaveFile(item.Key, bytes.NewReader(nil), 0); err != nil {
+			item.Status, item.Error = extractStatusFailed, err.Error()
+			return item
+		}
+		item.Status = extractStatusExtracted
+		return item
+	}
+
+	item.Key = buildObjectKey(userID, dir, name)
+	item.Size = entry.size
+	if err := validateObjectKey(item.Key); err != nil {
+		item.Status, item.Error = extractStatusFailed, err.Error()
+		return item
+	}
+	if !overwrite {
+		exists, err := storage.FileExists(item.Key)
+		if err != nil {
+			item.Status, item.Error = extractStatusFailed, err.Error()
+			return item
+		}
+		if exists {
+			item.Status, item.Error = extractStatusSkipped, "already exists"
+			return item
+		}
+	}
+
+	rc, err := open()
+	if err != nil {
+		item.Status, item.Error = extractStatusFailed, err.Error()
+		return item
+	}
+	defer rc.Close()
+
+	// 記録されたサイズより多くは読まない（サイズを偽ったエントリへの対策）
+	data := io.LimitReader(rc, entry.size)
+	entryOpts := opts
+	if entry.size <= extractBufferSize {
+		buf, err := io.ReadAll(data)
+		if err != nil {
+			item.Status, item.Error = extractStatusFailed, err.Error()
+			return item
+		}
+		if int64(len(buf)) != entry.size {
+			item.Status, item.Error = extractStatusFailed, io.ErrUnexpectedEOF.Error()
+			return item
+		}
+		body := bytes.NewReader(buf)
+		if entryOpts.ContentType, err = storage.SniffContentType(path.Base(name), "", body); err != nil {
+			item.Status, item.Error = extractStatusFailed, err.Error()
+			return item
+		}
+		err = storage.SaveFileWithOptions(item.Key, body, entry.size, entryOpts)
+	} else {
+		entryOpts.ContentType = storage.DetectContentType(path.Base(name), "", nil)
+		err = storage.SaveFileWithOptions(item.Key, data, entry.size, entryOpts)
+	}
+	if err != nil {
+		item.Status, item.Error = extractStatusFailed, err.Error()
+		return item
+	}
+	item.Status = extractStatusExtracted
+	return item
+}
+
+// 展開エラーに対応するHTTPステータス
+func extractErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrArchiveLimit):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, storage.ErrUnsupportedArchive):
+		return http.StatusBadRequest
+	}
+	return storageErrorStatus(err)
+}
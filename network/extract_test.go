@@ -0,0 +1,234 @@
+package network
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"os"
+	"testing"
+
+	"github.com/USlayout/go-minio/storage"
+)
+
+// multipart.File として扱えるメモリ上のファイル
+type memoryFile struct{ *bytes.Reader }
+
+func (memoryFile) Close() error { return nil }
+
+func newMemoryFile(data []byte) memoryFile {
+	return memoryFile{bytes.NewReader(data)}
+}
+
+type tarTestEntry struct {
+	header tar.Header
+	body   []byte
+}
+
+func buildTar(t *testing.T, entries []tarTestEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := entry.header
+		header.Size = int64(len(entry.body))
+		if header.Mode == 0 {
+			header.Mode = 0o644
+		}
+		if err := tw.WriteHeader(&header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(entry.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if _, err := gw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractEntryName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"file.txt", "file.txt", true},
+		{"dir/file.txt", "dir/file.txt", true},
+		{"dir/", "dir", true},
+		{"./file.txt", "file.txt", true},
+		{"././dir/file.txt", "dir/file.txt", true},
+		{"../evil.txt", "", false},
+		{"..", "", false},
+		{"dir/../../evil.txt", "", false},
+		{"a/../../b", "", false},
+		{"dir/..", "", false},
+		{"dir/./file.txt", "", false},
+		{"/abs/file.txt", "", false},
+		{"/", "", false},
+		{"..\\evil.txt", "", false},
+		{"dir\\file.txt", "", false},
+		{"C:\\Windows\\evil.txt", "", false},
+		{".", "", false},
+		{"./", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := extractEntryName(tt.name)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("extractEntryName(%q) = %q, %v; want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestWalkArchiveLinkEntries(t *testing.T) {
+	data := buildTar(t, []tarTestEntry{
+		{header: tar.Header{Name: "docs/readme.txt", Typeflag: tar.TypeReg}, body: []byte("hello")},
+		{header: tar.Header{Name: "docs/passwd", Typeflag: tar.TypeSymlink, Linkname: "../../../etc/passwd"}},
+		{header: tar.Header{Name: "docs/hard", Typeflag: tar.TypeLink, Linkname: "/etc/shadow"}},
+		{header: tar.Header{Name: "../escape", Typeflag: tar.TypeSymlink, Linkname: "/"}},
+		{header: tar.Header{Name: "docs/fifo", Typeflag: tar.TypeFifo}},
+	})
+
+	for _, format := range []string{"tar", "tar.gz"} {
+		t.Run(format, func(t *testing.T) {
+			archive := data
+			if format == "tar.gz" {
+				archive = gzipBytes(t, data)
+			}
+
+			var results []extractEntryResult
+			err := walkArchive(format, newMemoryFile(archive), int64(len(archive)), func(entry archiveEntry, open func() (io.ReadCloser, error)) error {
+				if entry.name == "docs/readme.txt" {
+					if !entry.regular {
+						t.Errorf("%s should be a regular entry", entry.name)
+					}
+					return nil
+				}
+				// リンク等は保存前に判定されるため、ストレージには触れない
+				results = append(results, extractEntry("user1", "dest", entry, open, &extractScan{parents: map[string]bool{}}, false, storage.UploadOptions{}))
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := map[string]string{
+				"docs/passwd": extractStatusSkipped,
+				"docs/hard":   extractStatusSkipped,
+				"../escape":   extractStatusFailed,
+				"docs/fifo":   extractStatusSkipped,
+			}
+			if len(results) != len(want) {
+				t.Fatalf("got %d results, want %d", len(results), len(want))
+			}
+			for _, result := range results {
+				if result.Status != want[result.Name] {
+					t.Errorf("%s: status = %q, want %q", result.Name, result.Status, want[result.Name])
+				}
+				if result.Key != "" {
+					t.Errorf("%s: key = %q, want none", result.Name, result.Key)
+				}
+			}
+
+			scan, err := scanArchive(format, newMemoryFile(archive), int64(len(archive)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if scan.files != 1 || scan.total != 5 {
+				t.Errorf("scan = %d files / %d bytes, want 1 / 5", scan.files, scan.total)
+			}
+		})
+	}
+}
+
+func TestWalkArchiveZipLinks(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	header := &zip.FileHeader{Name: "link"}
+	header.SetMode(0o777 | os.ModeSymlink)
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("../../etc/passwd"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = walkArchive("zip", newMemoryFile(buf.Bytes()), int64(buf.Len()), func(entry archiveEntry, open func() (io.ReadCloser, error)) error {
+		if entry.regular {
+			t.Errorf("%s: symlink should not be a regular entry", entry.name)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanArchiveGzipRatio(t *testing.T) {
+	// 圧縮率の高いエントリは全体を展開する前に中止する
+	bomb := buildTar(t, []tarTestEntry{
+		{header: tar.Header{Name: "zeros.bin", Typeflag: tar.TypeReg}, body: make([]byte, 64<<20)},
+		{header: tar.Header{Name: "after.txt", Typeflag: tar.TypeReg}, body: []byte("unreachable")},
+	})
+	archive := gzipBytes(t, bomb)
+	file := &countingReadSeeker{memoryFile: newMemoryFile(archive)}
+	_, err := scanArchive("tar.gz", file, int64(len(archive)))
+	if !errors.Is(err, storage.ErrArchiveLimit) {
+		t.Fatalf("err = %v, want %v", err, storage.ErrArchiveLimit)
+	}
+	if file.read >= int64(len(archive)) {
+		t.Errorf("read the whole archive (%d bytes) before rejecting it", file.read)
+	}
+
+	// 通常の圧縮率のアーカイブは受け付ける
+	text := make([]byte, 2<<20)
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range text {
+		text[i] = "abcdefghijklmnopqrstuvwxyz \n"[rng.IntN(28)]
+	}
+	normal := gzipBytes(t, buildTar(t, []tarTestEntry{
+		{header: tar.Header{Name: "log.txt", Typeflag: tar.TypeReg}, body: text},
+	}))
+	if ratio := int64(len(text)) / int64(len(normal)); ratio > storage.ExtractMaxRatio {
+		t.Fatalf("sample compresses too well (%d)", ratio)
+	}
+	scan, err := scanArchive("tar.gz", newMemoryFile(normal), int64(len(normal)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scan.total != int64(len(text)) {
+		t.Errorf("total = %d, want %d", scan.total, len(text))
+	}
+}
+
+// 読み取ったバイト数を記録するファイル
+type countingReadSeeker struct {
+	memoryFile
+	read int64
+}
+
+func (c *countingReadSeeker) Read(p []byte) (int, error) {
+	n, err := c.memoryFile.Read(p)
+	c.read += int64(n)
+	return n, err
+}
@@ -0,0 +1,113 @@
+package network
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/USlayout/go-minio/storage"
+)
+
+// 一覧取得の最大ページサイズ
+const maxListLimit = 1000
+
+// クエリパラメータから一覧のページング・並び替え・絞り込み条件を取得
+//
+//	limit, cursor, sort=name|size|modified|type, order=asc|desc,
+//	ext=pdf,txt  contentType=image/*  minSize / maxSize（バイト）
+//	modifiedAfter / modifiedBefore（RFC3339 または YYYY-MM-DD）
+func parseListOptions(r *http.Request) (storage.ListOptions, error) {
+	q := r.URL.Query()
+	opts := storage.ListOptions{
+		Cursor: q.Get("cursor"),
+		SortBy: q.Get("sort"),
+		Order:  q.Get("order"),
+	}
+
+	switch opts.SortBy {
+	case "", "name", "size", "modified", "type":
+	default:
+		return opts, errors.New("sort must be one of name, size, modified, type")
+	}
+	switch opts.Order {
+	case "", "asc", "desc":
+	default:
+		return opts, errors.New("order must be asc or desc")
+	}
+
+	if value := q.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return opts, errors.New("limit must be between 1 and " + strconv.Itoa(maxListLimit))
+		}
+		opts.Limit = limit
+	}
+
+	// tag=key:value または tag=key（複数指定はすべてに一致）
+	tags, err := parsePairs(q["tag"])
+	if err != nil {
+		return opts, err
+	}
+	opts.Tags = tags
+
+	opts.Extensions = splitList(q.Get("ext"))
+	opts.ContentTypes = splitList(q.Get("contentType"))
+
+	if opts.MinSize, err = parseSizeParam(q.Get("minSize")); err != nil {
+		return opts, errors.New("invalid minSize")
+	}
+	if opts.MaxSize, err = parseSizeParam(q.Get("maxSize")); err != nil {
+		return opts, errors.New("invalid maxSize")
+	}
+	if opts.ModifiedAfter, err = parseDateParam(q.Get("modifiedAfter")); err != nil {
+		return opts, errors.New("invalid modifiedAfter")
+	}
+	if opts.ModifiedBefore, err = parseDateParam(q.Get("modifiedBefore")); err != nil {
+		return opts, errors.New("invalid modifiedBefore")
+	}
+
+	return opts, nil
+}
+
+// カンマ区切りの値を分割（空要素は除外）
+func splitList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func parseSizeParam(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return 0, errors.New("invalid size")
+	}
+	return size, nil
+}
+
+// 日時パラメータを解析（日付のみの場合はUTCの0時）
+func parseDateParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// 一覧取得エラーをHTTPステータスに変換
+func listErrorStatus(err error) int {
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
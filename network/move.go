@@ -0,0 +1,179 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/USlayout/go-minio/storage"
+)
+
+// ファイル/フォルダ移動ハンドラー
+func handleMove(w http.ResponseWriter, r *http.Request) {
+	handleMoveRequest(w, r, false)
+}
+
+// ファイル/フォルダ名前変更ハンドラー
+func handleRename(w http.ResponseWriter, r *http.Request) {
+	handleMoveRequest(w, r, true)
+}
+
+// 移動・名前変更の共通処理
+// rename=true の場合は同じフォルダ内で newName に変更、false の場合は dest フォルダへ移動
+func handleMoveRequest(w http.ResponseWriter, r *http.Request, rename bool) {
+	// CORS設定
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 認証済みユーザーIDを取得
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		http.Error(w, "User ID not found in token", http.StatusBadRequest)
+		return
+	}
+
+	path := r.FormValue("path")
+	filename := r.FormValue("filename")
+	folder := r.FormValue("folder")
+	newName := r.FormValue("newName")
+	dest := r.FormValue("dest")
+	overwrite, _ := strconv.ParseBool(r.FormValue("overwrite"))
+	stream, _ := strconv.ParseBool(r.FormValue("stream"))
+
+	// 移動対象（ファイルまたはフォルダのどちらか一方）
+	name := filename
+	isFolder := false
+	if folder != "" {
+		name = folder
+		isFolder = true
+	}
+	if (filename == "") == (folder == "") {
+		http.Error(w, "Specify either filename or folder parameter", http.StatusBadRequest)
+		return
+	}
+
+	if rename {
+		if newName == "" {
+			http.Error(w, "Missing newName parameter", http.StatusBadRequest)
+			return
+		}
+		dest = path
+	} else if newName == "" {
+		newName = name
+	}
+
+	if err := validatePath(path); err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validatePath(dest); err != nil {
+		http.Error(w, "Invalid dest: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateName(name); err != nil {
+		http.Error(w, "Invalid source name: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateName(newName); err != nil {
+		http.Error(w, "Invalid newName: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	srcKey := buildObjectKey(userID, path, name)
+	dstKey := buildObjectKey(userID, dest, newName)
+	if err := validateObjectKey(dstKey); err != nil {
+		http.Error(w, "Invalid destination: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	action := "move"
+	if rename {
+		action = "rename"
+	}
+
+	// ファイルの場合は単一オブジェクトを移動
+	if !isFolder {
+		err := storage.MoveFile(srcKey, dstKey, overwrite)
+		if err != nil {
+			http.Error(w, "Move failed: "+err.Error(), storageErrorStatus(err))
+			return
+		}
+		recordActivity(userID, userID, action, path, name, false, spacePath(dest, newName))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"source":      srcKey,
+			"destination": dstKey,
+			"type":        "file",
+		})
+		return
+	}
+
+	// フォルダの場合は再帰的に移動（stream=true で進捗をNDJSONで逐次返す）
+	var progress func(storage.MoveProgress)
+	encoder := json.NewEncoder(w)
+	started := false
+	if stream {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		flusher, _ := w.(http.Flusher)
+		progress = func(p storage.MoveProgress) {
+			started = true
+			encoder.Encode(map[string]interface{}{"progress": p})
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+
+	result, err := storage.MoveFolder(srcKey, dstKey, overwrite, progress)
+	if err != nil {
+		if !stream {
+			http.Error(w, "Move failed: "+err.Error(), storageErrorStatus(err))
+			return
+		}
+		// 進捗を送信済みの場合はステータスを変更できないため、エラーも同じ形式の行で返す
+		if !started {
+			w.WriteHeader(storageErrorStatus(err))
+		}
+		encoder.Encode(map[string]interface{}{"error": "Move failed: " + err.Error()})
+		return
+	}
+	if result.Moved > 0 {
+		recordActivity(userID, userID, action, path, name, true, spacePath(dest, newName))
+	}
+
+	if stream {
+		encoder.Encode(map[string]interface{}{"result": result})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// storageのエラーをHTTPステータスに変換
+func storageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrAlreadyExists):
+		return http.StatusConflict
+	case errors.Is(err, storage.ErrMoveIntoSelf), errors.Is(err, storage.ErrCopyIntoSelf):
+		return http.StatusBadRequest
+	case errors.Is(err, storage.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, storage.ErrInvalidMetadata):
+		return http.StatusBadRequest
+	case errors.Is(err, storage.ErrChecksumMismatch):
+		return http.StatusBadRequest
+	case errors.Is(err, storage.ErrContentTypeNotAllowed):
+		return http.StatusUnsupportedMediaType
+	default:
+		return http.StatusInternalServerError
+	}
+}
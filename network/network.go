@@ -1,17 +1,23 @@
 package network
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/USlayout/go-minio/auth"
 	"github.com/USlayout/go-minio/storage"
 )
 
+// HTTPサーバ（ShutdownServer で停止する）
+var server = &http.Server{}
+
 func StartServer(addr string) error {
 	// 認証エンドポイント
 	http.HandleFunc("/auth/login", handleLogin)
@@ -23,8 +29,27 @@ func StartServer(addr string) error {
 	http.HandleFunc("/upload-multiple", auth.JWTMiddleware(handleMultipleUpload))
 	http.HandleFunc("/upload-folder", auth.JWTMiddleware(handleFolderUpload))
 	http.HandleFunc("/download", auth.JWTMiddleware(handleDownload))
+	http.HandleFunc("/download/archive", auth.JWTMiddleware(handleDownloadArchive))
+	http.HandleFunc("/thumbnail", auth.JWTMiddleware(handleThumbnail))
+	http.HandleFunc("/preview", auth.JWTMiddleware(handlePreview))
 	http.HandleFunc("/delete", auth.JWTMiddleware(handleDelete))
 	http.HandleFunc("/mkdir", auth.JWTMiddleware(handleMakeDir))
+	http.HandleFunc("/move", auth.JWTMiddleware(handleMove))
+	http.HandleFunc("/rename", auth.JWTMiddleware(handleRename))
+	http.HandleFunc("/copy", auth.JWTMiddleware(handleCopy))
+	http.HandleFunc("/trash", auth.JWTMiddleware(handleTrashList))
+	http.HandleFunc("/trash/restore", auth.JWTMiddleware(handleTrashRestore))
+	http.HandleFunc("/trash/empty", auth.JWTMiddleware(handleTrashEmpty))
+	http.HandleFunc("/versions", auth.JWTMiddleware(handleVersions))
+	http.HandleFunc("/tags", auth.JWTMiddleware(handleTags))
+	http.HandleFunc("/favorites", auth.JWTMiddleware(handleFavorites))
+	http.HandleFunc("/recent", auth.JWTMiddleware(handleRecent))
+	http.HandleFunc("/activity", auth.JWTMiddleware(handleActivity))
+	http.HandleFunc("/versions/restore", auth.JWTMiddleware(handleVersionRestore))
+	http.HandleFunc("/usage", auth.JWTMiddleware(handleUsage))
+	http.HandleFunc("/usage/breakdown", auth.JWTMiddleware(handleUsageBreakdown))
+	http.HandleFunc("/search", auth.JWTMiddleware(handleSearch))
+	http.HandleFunc("/search/content", auth.JWTMiddleware(handleContentSearch))
 	http.HandleFunc("/list", auth.JWTMiddleware(handleList))
 	http.HandleFunc("/list-details", auth.JWTMiddleware(handleListDetails))
 	http.HandleFunc("/list-folders", auth.JWTMiddleware(handleListFolders))
@@ -32,6 +57,10 @@ func StartServer(addr string) error {
 	http.HandleFunc("/size", auth.JWTMiddleware(handleFileSize))
 	http.HandleFunc("/metadata", auth.JWTMiddleware(handleFileMetadata)) // 管理者専用エンドポイント
 	http.HandleFunc("/admin/users", auth.AdminOnlyMiddleware(handleAdminUsers))
+	http.HandleFunc("/admin/content-types/backfill", auth.AdminOnlyMiddleware(handleContentTypeBackfill))
+	http.HandleFunc("/admin/dedup", auth.AdminOnlyMiddleware(handleDedupStats))
+	http.HandleFunc("/admin/dedup/gc", auth.AdminOnlyMiddleware(handleDedupGC))
+	http.HandleFunc("/admin/envelope/rewrap", auth.AdminOnlyMiddleware(handleEnvelopeRewrap))
 
 	// CORS対応
 	http.HandleFunc("/", corsMiddleware)
@@ -45,8 +74,32 @@ func StartServer(addr string) error {
 	fmt.Println("  POST /upload-multiple - 複数ファイルアップロード (要認証)")
 	fmt.Println("  POST /upload-folder - フォルダアップロード (要認証)")
 	fmt.Println("  GET  /download      - ファイルダウンロード (要認証)")
-	fmt.Println("  DELETE /delete      - ファイル削除 (要認証)")
+	fmt.Println("  GET  /download/archive - フォルダ・複数ファイルのZIP/tar.gz一括ダウンロード (要認証)")
+	fmt.Println("  GET  /thumbnail     - 画像のサムネイル取得 (要認証)")
+	fmt.Println("  GET  /preview       - テキスト・Markdown・CSV・JSON・ソースコードのプレビュー (要認証)")
+	fmt.Println("  DELETE /delete      - ファイル/フォルダ削除 (要認証)")
 	fmt.Println("  POST /mkdir         - フォルダ作成 (要認証)")
+	fmt.Println("  POST /move          - ファイル/フォルダ移動 (要認証)")
+	fmt.Println("  POST /rename        - ファイル/フォルダ名前変更 (要認証)")
+	fmt.Println("  POST /copy          - ファイル/フォルダ複製 (要認証)")
+	fmt.Println("  GET  /trash         - ゴミ箱一覧 (要認証)")
+	fmt.Println("  POST /trash/restore - ゴミ箱から復元 (要認証)")
+	fmt.Println("  DELETE /trash/empty - ゴミ箱を空にする (要認証)")
+	fmt.Println("  GET  /versions      - バージョン履歴取得 (要認証)")
+	fmt.Println("  DELETE /versions    - 特定バージョン削除 (要認証)")
+	fmt.Println("  POST /versions/restore - 過去バージョンの復元 (要認証)")
+	fmt.Println("  GET  /tags          - タグ取得 (要認証)")
+	fmt.Println("  POST /tags          - タグ追加・置換 (要認証)")
+	fmt.Println("  DELETE /tags        - タグ削除 (要認証)")
+	fmt.Println("  GET  /favorites     - お気に入り一覧 (要認証)")
+	fmt.Println("  POST /favorites     - お気に入りに追加 (要認証)")
+	fmt.Println("  DELETE /favorites   - お気に入りから削除 (要認証)")
+	fmt.Println("  GET  /recent        - 最近使ったファイル (要認証)")
+	fmt.Println("  GET  /activity      - アクティビティフィード (要認証)")
+	fmt.Println("  GET  /usage         - 使用量・クォータ取得 (要認証)")
+	fmt.Println("  GET  /usage/breakdown - フォルダ別・種類別の使用量内訳 (要認証)")
+	fmt.Println("  GET  /search        - ファイル検索 (要認証)")
+	fmt.Println("  GET  /search/content - 全文検索 (要認証)")
 	fmt.Println("  GET  /list          - ファイル/フォルダ一覧 (要認証)")
 	fmt.Println("  GET  /list-details  - ファイル/フォルダ詳細一覧 (要認証)")
 	fmt.Println("  GET  /list-folders  - フォルダ構造一覧 (要認証)")
@@ -54,14 +107,33 @@ func StartServer(addr string) error {
 	fmt.Println("  GET  /size          - ファイルサイズ取得 (要認証)")
 	fmt.Println("  GET  /metadata      - ファイルメタデータ取得 (要認証)")
 	fmt.Println("  GET  /admin/users   - ユーザー管理 (管理者のみ)")
+	fmt.Println("  POST /admin/content-types/backfill - 既存ファイルのコンテンツタイプ補完 (管理者のみ)")
+	fmt.Println("  GET  /admin/content-types/backfill - 補完ジョブの進捗 (管理者のみ)")
+	fmt.Println("  GET  /admin/dedup   - 重複排除の統計 (管理者のみ)")
+	fmt.Println("  POST /admin/dedup/gc - 未参照blobの削除 (管理者のみ)")
+	fmt.Println("  POST /admin/envelope/rewrap - 暗号化フォルダの鍵の入れ替え (管理者のみ)")
+	fmt.Println("  GET  /admin/envelope/rewrap - 鍵の入れ替えジョブの進捗 (管理者のみ)")
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	err = server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
 
-	return http.ListenAndServe(addr, nil)
+// 新しい接続の受け付けを止め、処理中のリクエストが終わるまで待つ
+func ShutdownServer(ctx context.Context) error {
+	return server.Shutdown(ctx)
 }
 
 func corsMiddleware(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Content-MD5, X-Checksum-Sha256")
 
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
@@ -189,8 +261,8 @@ func handleAdminUsers(w http.ResponseWriter, r *http.Request) {
 
 	// 簡単なユーザー一覧を返す（実際のアプリケーションではデータベースから取得）
 	users := []map[string]interface{}{
-		{"userID": "user123", "username": "testuser", "role": "user"},
-		{"userID": "admin", "username": "admin", "role": "admin"},
+		{"userID": "user123", "username": "testuser", "role": "user", "groups": []string{"dev"}},
+		{"userID": "admin", "username": "admin", "role": "admin", "groups": []string{"dev", "ops"}},
 	}
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -214,6 +286,11 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 本文を受信する前にクォータを確認
+	if !checkUploadQuota(w, r, userID) {
+		return
+	}
+
 	virtualPath := r.FormValue("path")
 	if virtualPath == "" {
 		virtualPath = "" // ルートディレクトリ
@@ -226,18 +303,59 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
+	// タグ・カスタムメタデータ（任意）
+	opts, err := parseUploadOptions(r)
+	if err != nil {
+		http.Error(w, "Invalid tag or metadata: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// extract=true の場合はアーカイブを展開して個別のファイルとして保存
+	if r.FormValue("extract") == "true" {
+		handleExtractUpload(w, r, userID, virtualPath, file, header, opts)
+		return
+	}
+
+	// コンテンツタイプを判定（許可されていない形式は415）
+	opts.ContentType, err = detectUploadContentType(file, header)
+	if err != nil {
+		http.Error(w, "Failed to read file", http.StatusBadRequest)
+		return
+	}
+
+	// Content-MD5 / X-Checksum-Sha256（任意、一致しない場合は保存しない）
+	opts.Checksums, err = uploadChecksums(r, header, true)
+	if err != nil {
+		http.Error(w, "Invalid checksum: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// オブジェクトキーを構築: <ユーザーID>/<仮想ディレクトリパス>/<ファイル名>
 	objectKey := buildObjectKey(userID, virtualPath, header.Filename)
 
-	err = storage.SaveFile(objectKey, file, header.Size)
+	err = storage.SaveFileWithOptions(objectKey, file, header.Size, opts)
 	if err != nil {
-		http.Error(w, "Upload failed: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Upload failed: "+err.Error(), storageErrorStatus(err))
 		return
 	}
+	recordActivity(userID, userID, "upload", virtualPath, header.Filename, false, "")
+	recordRecent(userID, "upload", virtualPath, header.Filename)
 
 	fmt.Fprintf(w, "Uploaded: %s\n", objectKey)
 }
 
+// Content-Lengthでアップロード前にクォータを確認（超過時は507を返してfalse）
+func checkUploadQuota(w http.ResponseWriter, r *http.Request, userID string) bool {
+	if r.ContentLength <= 0 {
+		return true
+	}
+	if err := storage.CheckQuota(userID+"/", r.ContentLength, 1); err != nil {
+		http.Error(w, "Upload rejected: "+err.Error(), storageErrorStatus(err))
+		return false
+	}
+	return true
+}
+
 // オブジェクトキーを構築する関数
 func buildObjectKey(userID, virtualPath, filename string) string {
 	if virtualPath == "" {
@@ -281,6 +399,8 @@ func handleMakeDir(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to create folder: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	parent, name := splitSpacePath(folderPath)
+	recordActivity(userID, userID, "mkdir", parent, name, true, "")
 
 	fmt.Fprintf(w, "Folder created: %s/%s\n", userID, folderPath)
 }
@@ -302,6 +422,11 @@ func handleMultipleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 本文を受信する前にクォータを確認
+	if !checkUploadQuota(w, r, userID) {
+		return
+	}
+
 	virtualPath := r.FormValue("path")
 	if virtualPath == "" {
 		virtualPath = "" // ルートディレクトリ
@@ -320,6 +445,23 @@ func handleMultipleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 全ファイル共通のタグ・カスタムメタデータ（任意）
+	opts, err := parseUploadOptions(r)
+	if err != nil {
+		http.Error(w, "Invalid tag or metadata: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 全ファイルの合計サイズ・件数でクォータを確認
+	var totalSize int64
+	for _, fileHeader := range files {
+		totalSize += fileHeader.Size
+	}
+	if err := storage.CheckQuota(userID+"/", totalSize, int64(len(files))); err != nil {
+		http.Error(w, "Upload rejected: "+err.Error(), storageErrorStatus(err))
+		return
+	}
+
 	var uploadedFiles []string
 	var errors []string
 
@@ -330,16 +472,33 @@ func handleMultipleUpload(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
+		// ファイルごとにコンテンツタイプとチェックサムを判定
+		fileOpts := opts
+		fileOpts.ContentType, err = detectUploadContentType(file, fileHeader)
+		if err != nil {
+			file.Close()
+			errors = append(errors, fmt.Sprintf("Failed to read %s: %v", fileHeader.Filename, err))
+			continue
+		}
+		fileOpts.Checksums, err = uploadChecksums(r, fileHeader, false)
+		if err != nil {
+			file.Close()
+			errors = append(errors, fmt.Sprintf("Invalid checksum for %s: %v", fileHeader.Filename, err))
+			continue
+		}
+
 		// オブジェクトキーを構築（フォルダ構造を維持）
 		objectKey := buildObjectKey(userID, virtualPath, fileHeader.Filename)
 
-		err = storage.SaveFile(objectKey, file, fileHeader.Size)
+		err = storage.SaveFileWithOptions(objectKey, file, fileHeader.Size, fileOpts)
 		file.Close()
 
 		if err != nil {
 			errors = append(errors, fmt.Sprintf("Failed to save %s: %v", objectKey, err))
 		} else {
 			uploadedFiles = append(uploadedFiles, objectKey)
+			recordActivity(userID, userID, "upload", virtualPath, fileHeader.Filename, false, "")
+			recordRecent(userID, "upload", virtualPath, fileHeader.Filename)
 		}
 	}
 
@@ -373,6 +532,11 @@ func handleFolderUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 本文を受信する前にクォータを確認
+	if !checkUploadQuota(w, r, userID) {
+		return
+	}
+
 	virtualPath := r.FormValue("path")
 	if virtualPath == "" {
 		virtualPath = "" // ルートディレクトリ
@@ -391,6 +555,23 @@ func handleFolderUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 全ファイル共通のタグ・カスタムメタデータ（任意）
+	opts, err := parseUploadOptions(r)
+	if err != nil {
+		http.Error(w, "Invalid tag or metadata: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 全ファイルの合計サイズ・件数でクォータを確認
+	var totalSize int64
+	for _, fileHeader := range files {
+		totalSize += fileHeader.Size
+	}
+	if err := storage.CheckQuota(userID+"/", totalSize, int64(len(files))); err != nil {
+		http.Error(w, "Upload rejected: "+err.Error(), storageErrorStatus(err))
+		return
+	}
+
 	var uploadedFiles []string
 	var errors []string
 
@@ -401,16 +582,33 @@ func handleFolderUpload(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
+		// ファイルごとにコンテンツタイプとチェックサムを判定
+		fileOpts := opts
+		fileOpts.ContentType, err = detectUploadContentType(file, fileHeader)
+		if err != nil {
+			file.Close()
+			errors = append(errors, fmt.Sprintf("Failed to read %s: %v", fileHeader.Filename, err))
+			continue
+		}
+		fileOpts.Checksums, err = uploadChecksums(r, fileHeader, false)
+		if err != nil {
+			file.Close()
+			errors = append(errors, fmt.Sprintf("Invalid checksum for %s: %v", fileHeader.Filename, err))
+			continue
+		}
+
 		// オブジェクトキーを構築（ユーザー認証対応）
 		objectKey := buildObjectKey(userID, virtualPath, fileHeader.Filename)
 
-		err = storage.SaveFile(objectKey, file, fileHeader.Size)
+		err = storage.SaveFileWithOptions(objectKey, file, fileHeader.Size, fileOpts)
 		file.Close()
 
 		if err != nil {
 			errors = append(errors, fmt.Sprintf("Failed to save %s: %v", objectKey, err))
 		} else {
 			uploadedFiles = append(uploadedFiles, objectKey)
+			recordActivity(userID, userID, "upload", virtualPath, fileHeader.Filename, false, "")
+			recordRecent(userID, "upload", virtualPath, fileHeader.Filename)
 		}
 	}
 
@@ -446,7 +644,7 @@ func handleListFolders(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// ユーザー専用のフォルダ構造を取得（ルートから）
-	folders, err := storage.ListUserFilesWithDetails(userID, "")
+	folders, err := storage.ListUserFilesWithDetails(userID, "", storage.ListOptions{})
 	if err != nil {
 		http.Error(w, "Failed to list folders: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -477,18 +675,33 @@ func handleDownload(w http.ResponseWriter, r *http.Request) {
 	// オブジェクトキーを構築
 	objectKey := buildObjectKey(userID, path, filename)
 
-	reader, err := storage.GetFile(objectKey)
+	// versionId 指定時は過去のバージョンを取得
+	var reader io.ReadSeekCloser
+	var info *storage.FileInfo
+	var err error
+	if versionID := r.URL.Query().Get("versionId"); versionID != "" {
+		reader, info, err = storage.OpenFileVersion(objectKey, versionID)
+	} else {
+		reader, info, err = storage.OpenFile(objectKey)
+	}
 	if err != nil {
 		http.Error(w, "Download failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 	defer reader.Close()
 
-	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
-	_, err = io.Copy(w, reader)
-	if err != nil {
-		log.Printf("Error copying file: %v", err)
-	}
+	// inline=true の場合はプレビュー可能な形式のみブラウザ内表示（HTML/SVGは常に添付）
+	wantInline, _ := strconv.ParseBool(r.URL.Query().Get("inline"))
+	contentType := resolveContentType(info.ContentType, filename)
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", contentDisposition(dispositionType(wantInline, contentType, filename), filename))
+	setDigestHeaders(w, info.Checksums)
+	recordRecent(userID, "download", path, filename)
+
+	// Range 指定時は必要な部分のみ返す（暗号化・圧縮されたファイルも該当するチャンク・フレームのみ復元する）
+	http.ServeContent(w, r, filename, info.LastModified, reader)
 }
 
 // ファイル一覧を返すハンドラー
@@ -511,10 +724,16 @@ func handleList(w http.ResponseWriter, r *http.Request) {
 
 	path := r.URL.Query().Get("path")
 
+	opts, err := parseListOptions(r)
+	if err != nil {
+		http.Error(w, "Invalid parameter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// 階層構造でファイル/フォルダ一覧を取得
-	items, err := storage.ListUserFiles(userID, path)
+	items, err := storage.ListUserFiles(userID, path, opts)
 	if err != nil {
-		http.Error(w, "Failed to list files: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to list files: "+err.Error(), listErrorStatus(err))
 		return
 	}
 
@@ -541,10 +760,16 @@ func handleListDetails(w http.ResponseWriter, r *http.Request) {
 
 	path := r.URL.Query().Get("path")
 
+	opts, err := parseListOptions(r)
+	if err != nil {
+		http.Error(w, "Invalid parameter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// 詳細な階層構造でファイル/フォルダ一覧を取得
-	items, err := storage.ListUserFilesWithDetails(userID, path)
+	items, err := storage.ListUserFilesWithDetails(userID, path, opts)
 	if err != nil {
-		http.Error(w, "Failed to list files: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to list files: "+err.Error(), listErrorStatus(err))
 		return
 	}
 
@@ -570,6 +795,13 @@ func handleDelete(w http.ResponseWriter, r *http.Request) {
 
 	path := r.URL.Query().Get("path")
 	filename := r.URL.Query().Get("filename")
+	folder := r.URL.Query().Get("folder")
+
+	// フォルダ指定の場合は配下を再帰的に削除
+	if folder != "" {
+		handleDeleteFolder(w, r, userID, path, folder)
+		return
+	}
 
 	if filename == "" {
 		http.Error(w, "Missing filename parameter", http.StatusBadRequest)
@@ -579,13 +811,77 @@ func handleDelete(w http.ResponseWriter, r *http.Request) {
 	// オブジェクトキーを構築
 	objectKey := buildObjectKey(userID, path, filename)
 
-	err := storage.DeleteFile(objectKey)
+	// permanent=true の場合のみ即時削除、それ以外はゴミ箱へ移動
+	permanent, _ := strconv.ParseBool(r.URL.Query().Get("permanent"))
+	if permanent {
+		err := storage.DeleteFile(objectKey)
+		if err != nil {
+			http.Error(w, "Delete failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordActivity(userID, userID, "delete", path, filename, false, "")
+
+		fmt.Fprintf(w, "Deleted: %s\n", objectKey)
+		return
+	}
+
+	entry, err := storage.TrashFile(userID, objectKey)
 	if err != nil {
-		http.Error(w, "Delete failed: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Delete failed: "+err.Error(), storageErrorStatus(err))
+		return
+	}
+	recordActivity(userID, userID, "trash", path, filename, false, "")
+
+	fmt.Fprintf(w, "Moved to trash: %s (id: %s)\n", objectKey, entry.ID)
+}
+
+// フォルダ再帰削除（dryRun=true で削除対象の一覧のみ返す、permanent=true で即時削除）
+func handleDeleteFolder(w http.ResponseWriter, r *http.Request, userID, path, folder string) {
+	if err := validatePath(path); err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateName(folder); err != nil {
+		http.Error(w, "Invalid folder: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	fmt.Fprintf(w, "Deleted: %s\n", objectKey)
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+	permanent, _ := strconv.ParseBool(r.URL.Query().Get("permanent"))
+	prefix := buildObjectKey(userID, path, folder)
+
+	// 通常はゴミ箱へ移動
+	if !dryRun && !permanent {
+		entry, result, err := storage.TrashFolder(userID, prefix)
+		if err != nil {
+			http.Error(w, "Delete failed: "+err.Error(), storageErrorStatus(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if entry == nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		} else {
+			recordActivity(userID, userID, "trash", path, folder, true, "")
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"trash":  entry,
+			"result": result,
+		})
+		return
+	}
+
+	result, err := storage.DeleteFolder(prefix, dryRun)
+	if err != nil {
+		http.Error(w, "Delete failed: "+err.Error(), storageErrorStatus(err))
+		return
+	}
+	if !dryRun && result.Deleted > 0 {
+		recordActivity(userID, userID, "delete", path, folder, true, "")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
 }
 
 // ファイル詳細情報取得ハンドラー
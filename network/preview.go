@@ -0,0 +1,244 @@
+package network
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/USlayout/go-minio/storage"
+)
+
+// プレビューの種類
+const (
+	previewText     = "text"
+	previewMarkdown = "markdown"
+	previewCSV      = "csv"
+	previewJSON     = "json"
+	previewCode     = "code"
+)
+
+// ソースコードの拡張子と言語名（シンタックスハイライト用）
+var previewLanguages = map[string]string{
+	".go":    "go",
+	".py":    "python",
+	".js":    "javascript",
+	".mjs":   "javascript",
+	".jsx":   "jsx",
+	".ts":    "typescript",
+	".tsx":   "tsx",
+	".java":  "java",
+	".kt":    "kotlin",
+	".swift": "swift",
+	".c":     "c",
+	".h":     "c",
+	".cpp":   "cpp",
+	".cc":    "cpp",
+	".hpp":   "cpp",
+	".cs":    "csharp",
+	".rs":    "rust",
+	".rb":    "ruby",
+	".php":   "php",
+	".sh":    "bash",
+	".bash":  "bash",
+	".ps1":   "powershell",
+	".sql":   "sql",
+	".html":  "html",
+	".htm":   "html",
+	".css":   "css",
+	".scss":  "scss",
+	".xml":   "xml",
+	".yaml":  "yaml",
+	".yml":   "yaml",
+	".toml":  "toml",
+	".ini":   "ini",
+	".vue":   "vue",
+	".dart":  "dart",
+	".lua":   "lua",
+}
+
+// プレビューのレスポンス
+type previewResponse struct {
+	Name         string     `json:"name"`
+	Path         string     `json:"path"`
+	Kind         string     `json:"kind"`
+	ContentType  string     `json:"contentType"`
+	Language     string     `json:"language,omitempty"`
+	Size         int64      `json:"size"`
+	PreviewBytes int        `json:"previewBytes"`
+	Truncated    bool       `json:"truncated"`
+	LineCount    int        `json:"lineCount"`
+	Content      string     `json:"content,omitempty"`
+	Rows         [][]string `json:"rows,omitempty"`
+	Formatted    bool       `json:"formatted,omitempty"` // JSONを整形できたか（途中で切れている場合は元のまま）
+}
+
+// ファイル名とコンテンツタイプからプレビューの種類と言語を判定（対象外の場合は空）
+func previewKind(filename, contentType string) (kind, language string) {
+	ext := strings.ToLower(path.Ext(filename))
+	mediaType := baseMediaType(contentType)
+	switch {
+	case ext == ".md" || ext == ".markdown" || mediaType == "text/markdown":
+		return previewMarkdown, "markdown"
+	case ext == ".csv" || ext == ".tsv" || mediaType == "text/csv" || mediaType == "text/tab-separated-values":
+		return previewCSV, ""
+	case ext == ".json" || mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return previewJSON, "json"
+	case previewLanguages[ext] != "":
+		return previewCode, previewLanguages[ext]
+	case strings.HasPrefix(mediaType, "text/") || ext == ".txt" || ext == ".log":
+		return previewText, ""
+	}
+	return "", ""
+}
+
+// 読み取った先頭部分を有効なUTF-8の文字列にする（途中で切れた末尾の文字は取り除く）
+func previewString(data []byte, truncated bool) string {
+	if truncated {
+		for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(data[i]) {
+				if !utf8.FullRune(data[i:]) {
+					data = data[:i]
+				}
+				break
+			}
+		}
+	}
+	return strings.ToValidUTF8(string(data), "�")
+}
+
+// CSV・TSVを表として解析（途中で切れた最終行は含めない）
+func previewRows(text, filename string, truncated bool) [][]string {
+	if truncated {
+		if i := strings.LastIndexByte(text, '\n'); i >= 0 {
+			text = text[:i+1]
+		}
+	}
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	if strings.EqualFold(path.Ext(filename), ".tsv") {
+		reader.Comma = '\t'
+	}
+
+	rows := [][]string{}
+	for int64(len(rows)) < storage.PreviewMaxRows {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			continue
+		}
+		rows = append(rows, record)
+	}
+	return rows
+}
+
+// テキスト系ファイルの先頭部分をプレビュー用に返すハンドラー
+// 範囲指定で先頭 maxBytes バイトのみ読み取るため、大きなファイルでも負荷は一定
+func handlePreview(w http.ResponseWriter, r *http.Request) {
+	// CORS設定
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 認証済みユーザーIDを取得
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		http.Error(w, "User ID not found in token", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	dir := query.Get("path")
+	filename := query.Get("filename")
+	if filename == "" {
+		http.Error(w, "Missing filename parameter", http.StatusBadRequest)
+		return
+	}
+	if err := validatePath(dir); err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateName(filename); err != nil {
+		http.Error(w, "Invalid filename: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	maxBytes := storage.PreviewMaxBytes
+	if value := query.Get("maxBytes"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid parameter: maxBytes must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		maxBytes = min(n, storage.PreviewMaxBytes)
+	}
+
+	root, err := resolveSpaceRoot(r, userID, query.Get("space"))
+	if err != nil {
+		http.Error(w, "Invalid space: "+err.Error(), spaceErrorStatus(err))
+		return
+	}
+
+	data, info, err := storage.ReadFileHead(buildObjectKey(root, dir, filename), maxBytes)
+	if err != nil {
+		http.Error(w, "Preview failed: "+err.Error(), storageErrorStatus(err))
+		return
+	}
+
+	contentType := resolveContentType(info.ContentType, filename)
+	kind, language := previewKind(filename, contentType)
+	// NULバイトを含むものはテキストとして扱わない
+	if kind == "" || bytes.IndexByte(data, 0) >= 0 {
+		http.Error(w, "Preview not available for this file type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	truncated := info.Size > int64(len(data))
+	text := previewString(data, truncated)
+	response := previewResponse{
+		Name:         filename,
+		Path:         strings.Trim(dir, "/"),
+		Kind:         kind,
+		ContentType:  contentType,
+		Language:     language,
+		Size:         info.Size,
+		PreviewBytes: len(text),
+		Truncated:    truncated,
+		LineCount:    strings.Count(text, "\n"),
+	}
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		response.LineCount++
+	}
+
+	switch kind {
+	case previewCSV:
+		response.Rows = previewRows(text, filename, truncated)
+	case previewJSON:
+		// 全体を読み取れた場合のみ整形する
+		var formatted bytes.Buffer
+		if !truncated && json.Indent(&formatted, []byte(text), "", "  ") == nil {
+			response.Content = formatted.String()
+			response.Formatted = true
+		} else {
+			response.Content = text
+		}
+	default:
+		response.Content = text
+	}
+
+	// json.Encoder は < > & をエスケープするため、HTMLに埋め込んでも安全
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,200 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/USlayout/go-minio/auth"
+	"github.com/USlayout/go-minio/storage"
+)
+
+const (
+	// 検索結果の既定件数
+	defaultSearchLimit = 100
+	// 全文検索結果の既定件数と上限
+	defaultContentSearchLimit = 20
+	maxContentSearchLimit     = 100
+)
+
+// 検索対象のスペースを解決
+// space 指定時はそのチームスペース、personal=true の場合は個人スペースのみ、
+// いずれもなければ個人スペースと所属グループの全チームスペース
+func searchRoots(r *http.Request, userID string) ([]string, error) {
+	query := r.URL.Query()
+	personal, _ := strconv.ParseBool(query.Get("personal"))
+	if space := query.Get("space"); space != "" || personal {
+		root, err := resolveSpaceRoot(r, userID, space)
+		if err != nil {
+			return nil, err
+		}
+		return []string{root}, nil
+	}
+
+	roots := []string{userID}
+	for _, group := range auth.GetUserGroups(r) {
+		roots = append(roots, teamSpacePrefix+group)
+	}
+	return roots, nil
+}
+
+// ファイル検索ハンドラー（個人スペースと所属グループのチームスペースを横断）
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	// CORS設定
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 認証済みユーザーIDを取得
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		http.Error(w, "User ID not found in token", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	path := query.Get("path")
+	if err := validatePath(path); err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	roots, err := searchRoots(r, userID)
+	if err != nil {
+		http.Error(w, "Invalid space: "+err.Error(), spaceErrorStatus(err))
+		return
+	}
+	if trimmed := strings.Trim(path, "/"); trimmed != "" {
+		for i := range roots {
+			roots[i] += "/" + trimmed
+		}
+	}
+
+	opts, err := parseListOptions(r)
+	if err != nil {
+		http.Error(w, "Invalid parameter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Limit == 0 {
+		opts.Limit = defaultSearchLimit
+	}
+
+	search := storage.SearchQuery{
+		Roots:   roots,
+		Name:    query.Get("q"),
+		Options: opts,
+		Camera:  query.Get("camera"),
+	}
+	if search.TakenAfter, err = parseDateParam(query.Get("takenAfter")); err != nil {
+		http.Error(w, "Invalid parameter: invalid takenAfter", http.StatusBadRequest)
+		return
+	}
+	if search.TakenBefore, err = parseDateParam(query.Get("takenBefore")); err != nil {
+		http.Error(w, "Invalid parameter: invalid takenBefore", http.StatusBadRequest)
+		return
+	}
+	for param, target := range map[string]*int{"minWidth": &search.MinWidth, "minHeight": &search.MinHeight} {
+		if value := query.Get(param); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				http.Error(w, "Invalid parameter: invalid "+param, http.StatusBadRequest)
+				return
+			}
+			*target = n
+		}
+	}
+
+	hits, nextCursor, err := storage.SearchFiles(search)
+	if err != nil {
+		status := listErrorStatus(err)
+		if errors.Is(err, storage.ErrInvalidPattern) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, "Search failed: "+err.Error(), status)
+		return
+	}
+
+	json.NewEncoder(w).Encode(storage.SearchResults{
+		SchemaVersion: storage.SchemaVersion,
+		Query:         query.Get("q"),
+		Results:       hits,
+		NextCursor:    nextCursor,
+	})
+}
+
+// 全文検索ハンドラー（本文・ファイル名をスコア順に検索）
+func handleContentSearch(w http.ResponseWriter, r *http.Request) {
+	// CORS設定
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 認証済みユーザーIDを取得
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		http.Error(w, "User ID not found in token", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		http.Error(w, "Missing q parameter", http.StatusBadRequest)
+		return
+	}
+
+	roots, err := searchRoots(r, userID)
+	if err != nil {
+		http.Error(w, "Invalid space: "+err.Error(), spaceErrorStatus(err))
+		return
+	}
+
+	limit := defaultContentSearchLimit
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxContentSearchLimit {
+			http.Error(w, "Invalid parameter: limit must be between 1 and "+strconv.Itoa(maxContentSearchLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	offset := 0
+	if value := query.Get("offset"); value != "" {
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			http.Error(w, "Invalid parameter: invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+
+	hits, total, err := storage.SearchContent(storage.FullTextQuery{
+		Roots:  roots,
+		Query:  q,
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, storage.ErrFullTextUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, "Search failed: "+err.Error(), status)
+		return
+	}
+
+	json.NewEncoder(w).Encode(storage.FullTextResults{
+		SchemaVersion: storage.SchemaVersion,
+		Query:         q,
+		Total:         total,
+		Results:       hits,
+	})
+}
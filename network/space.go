@@ -0,0 +1,28 @@
+package network
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/USlayout/go-minio/auth"
+)
+
+// チームスペースのオブジェクトキー接頭辞（teams/<グループ名>/...）
+const teamSpacePrefix = "teams/"
+
+var errSpaceForbidden = errors.New("no write access to space")
+
+// スペース名からルートプレフィックスを解決
+// 空の場合は個人スペース（<ユーザーID>）、それ以外は所属グループのチームスペース
+func resolveSpaceRoot(r *http.Request, userID, space string) (string, error) {
+	if space == "" {
+		return userID, nil
+	}
+	if err := validateName(space); err != nil {
+		return "", err
+	}
+	if !auth.IsGroupMember(r, space) {
+		return "", errSpaceForbidden
+	}
+	return teamSpacePrefix + space, nil
+}
@@ -0,0 +1,117 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/USlayout/go-minio/storage"
+)
+
+// "key:value" 形式の値を解析（値は省略可、同じキーは後勝ち）
+func parsePairs(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	pairs := map[string]string{}
+	for _, value := range values {
+		key, v, _ := strings.Cut(value, ":")
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, errors.New("empty key in " + strconv.Quote(value))
+		}
+		pairs[key] = v
+	}
+	return pairs, nil
+}
+
+// アップロードフォームからタグ（tag=key:value）とメタデータ（meta=key:value）を取得
+// ParseMultipartForm / FormFile の後に呼び出すこと
+func parseUploadOptions(r *http.Request) (storage.UploadOptions, error) {
+	var opts storage.UploadOptions
+	var err error
+	if opts.Tags, err = parsePairs(r.Form["tag"]); err != nil {
+		return opts, err
+	}
+	if opts.Metadata, err = parsePairs(r.Form["meta"]); err != nil {
+		return opts, err
+	}
+	// stripLocation（省略時は IMAGE_STRIP_LOCATION）
+	opts.StripLocation = storage.ImageStripLocation
+	if value := r.FormValue("stripLocation"); value != "" {
+		if opts.StripLocation, err = strconv.ParseBool(value); err != nil {
+			return opts, errors.New("stripLocation must be true or false")
+		}
+	}
+	return opts, nil
+}
+
+// タグ操作ハンドラー（GET: 取得、POST: 追加・置換、DELETE: 削除）
+func handleTags(w http.ResponseWriter, r *http.Request) {
+	// CORS設定
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 認証済みユーザーIDを取得
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		http.Error(w, "User ID not found in token", http.StatusBadRequest)
+		return
+	}
+
+	path := r.FormValue("path")
+	filename := r.FormValue("filename")
+
+	if filename == "" {
+		http.Error(w, "Missing filename parameter", http.StatusBadRequest)
+		return
+	}
+
+	// オブジェクトキーを構築
+	objectKey := buildObjectKey(userID, path, filename)
+
+	var tags map[string]string
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		tags, err = storage.GetTags(objectKey)
+
+	case http.MethodPost:
+		// replace=true の場合は既存のタグを全て置き換える
+		var newTags map[string]string
+		newTags, err = parsePairs(r.Form["tag"])
+		if err != nil {
+			http.Error(w, "Invalid tag: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(newTags) == 0 {
+			http.Error(w, "Missing tag parameter", http.StatusBadRequest)
+			return
+		}
+		replace, _ := strconv.ParseBool(r.FormValue("replace"))
+		tags, err = storage.SetTags(objectKey, newTags, replace)
+
+	case http.MethodDelete:
+		// key を指定しない場合は全てのタグを削除
+		tags, err = storage.RemoveTags(objectKey, r.Form["key"])
+	}
+	if err != nil {
+		http.Error(w, "Tag operation failed: "+err.Error(), storageErrorStatus(err))
+		return
+	}
+	if r.Method != http.MethodGet {
+		recordActivity(userID, userID, "tag", path, filename, false, "")
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name": objectKey,
+		"tags": tags,
+	})
+}
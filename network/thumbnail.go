@@ -0,0 +1,88 @@
+package network
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/USlayout/go-minio/storage"
+)
+
+// 画像ファイルのサムネイルを返すハンドラー
+// 生成前の場合は 202 Accepted を返す（Retry-After の秒数後に再取得する）
+func handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	// CORS設定
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 認証済みユーザーIDを取得
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		http.Error(w, "User ID not found in token", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	dir := query.Get("path")
+	filename := query.Get("filename")
+	if filename == "" {
+		http.Error(w, "Missing filename parameter", http.StatusBadRequest)
+		return
+	}
+	if err := validatePath(dir); err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateName(filename); err != nil {
+		http.Error(w, "Invalid filename: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	size := 256
+	if value := query.Get("size"); value != "" {
+		var err error
+		if size, err = strconv.Atoi(value); err != nil || size <= 0 {
+			http.Error(w, "Invalid parameter: size must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = "jpeg"
+	}
+	if !storage.IsThumbnailFormat(format) {
+		http.Error(w, "Invalid parameter: unsupported format "+format, http.StatusBadRequest)
+		return
+	}
+
+	root, err := resolveSpaceRoot(r, userID, query.Get("space"))
+	if err != nil {
+		http.Error(w, "Invalid space: "+err.Error(), spaceErrorStatus(err))
+		return
+	}
+
+	reader, info, err := storage.OpenThumbnail(buildObjectKey(root, dir, filename), size, format)
+	switch {
+	case errors.Is(err, storage.ErrThumbnailPending):
+		w.Header().Set("Retry-After", "2")
+		http.Error(w, err.Error(), http.StatusAccepted)
+		return
+	case errors.Is(err, storage.ErrThumbnailUnavailable):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, "Thumbnail failed: "+err.Error(), storageErrorStatus(err))
+		return
+	}
+	defer reader.Close()
+
+	// 元のファイルが更新されるとサムネイルも作り直されるため、更新日時で再検証させる
+	w.Header().Set("Content-Type", info.ContentType)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", info.LastModified, reader)
+}
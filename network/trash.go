@@ -0,0 +1,114 @@
+package network
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/USlayout/go-minio/storage"
+)
+
+// ゴミ箱一覧ハンドラー
+func handleTrashList(w http.ResponseWriter, r *http.Request) {
+	// CORS設定
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 認証済みユーザーIDを取得
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		http.Error(w, "User ID not found in token", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := storage.ListTrash(userID)
+	if err != nil {
+		http.Error(w, "Failed to list trash: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"userID":    userID,
+		"entries":   entries,
+		"retention": storage.TrashRetention.String(),
+	})
+}
+
+// ゴミ箱から復元するハンドラー
+func handleTrashRestore(w http.ResponseWriter, r *http.Request) {
+	// CORS設定
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 認証済みユーザーIDを取得
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		http.Error(w, "User ID not found in token", http.StatusBadRequest)
+		return
+	}
+
+	id := r.FormValue("id")
+	if err := validateName(id); err != nil {
+		http.Error(w, "Invalid id parameter", http.StatusBadRequest)
+		return
+	}
+	overwrite, _ := strconv.ParseBool(r.FormValue("overwrite"))
+
+	entry, result, err := storage.RestoreTrash(userID, id, overwrite)
+	if err != nil {
+		http.Error(w, "Restore failed: "+err.Error(), storageErrorStatus(err))
+		return
+	}
+	parent, name := splitSpacePath(entry.OriginalPath)
+	recordActivity(userID, userID, "restore", parent, name, entry.Type == "folder", "")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"restored": entry,
+		"result":   result,
+	})
+}
+
+// ゴミ箱を空にするハンドラー（id指定時はそのエントリのみ完全削除）
+func handleTrashEmpty(w http.ResponseWriter, r *http.Request) {
+	// CORS設定
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 認証済みユーザーIDを取得
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		http.Error(w, "User ID not found in token", http.StatusBadRequest)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id != "" {
+		if err := validateName(id); err != nil {
+			http.Error(w, "Invalid id parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := storage.EmptyTrash(userID, id)
+	if err != nil {
+		http.Error(w, "Failed to empty trash: "+err.Error(), storageErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,74 @@
+package network
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/USlayout/go-minio/auth"
+	"github.com/USlayout/go-minio/storage"
+)
+
+// 使用量・クォータ取得ハンドラー（本人と所属グループ）
+func handleUsage(w http.ResponseWriter, r *http.Request) {
+	// CORS設定
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 認証済みユーザーIDを取得
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		http.Error(w, "User ID not found in token", http.StatusBadRequest)
+		return
+	}
+
+	groups := []storage.Usage{}
+	for _, group := range auth.GetUserGroups(r) {
+		groups = append(groups, storage.GetGroupUsage(group))
+	}
+
+	user := storage.GetUserUsage(userID)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user":       user,
+		"bytesHuman": formatSize(user.Bytes),
+		"groups":     groups,
+	})
+}
+
+// 使用量の内訳ハンドラー（トップレベルフォルダ別・コンテンツタイプ別）
+func handleUsageBreakdown(w http.ResponseWriter, r *http.Request) {
+	// CORS設定
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 認証済みユーザーIDを取得
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		http.Error(w, "User ID not found in token", http.StatusBadRequest)
+		return
+	}
+
+	// space にグループ名を指定するとチームスペースの内訳
+	root, err := resolveSpaceRoot(r, userID, r.URL.Query().Get("space"))
+	if err != nil {
+		http.Error(w, "Invalid space: "+err.Error(), spaceErrorStatus(err))
+		return
+	}
+
+	breakdown, err := storage.GetUsageBreakdown(root)
+	if err != nil {
+		http.Error(w, "Failed to get usage breakdown: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(breakdown)
+}
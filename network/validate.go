@@ -0,0 +1,60 @@
+package network
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// S3のオブジェクトキー長の上限（バイト）
+const maxObjectKeyLength = 1024
+
+var (
+	errInvalidName = errors.New("invalid name")
+	errInvalidPath = errors.New("invalid path")
+	errKeyTooLong  = errors.New("object key too long")
+)
+
+// ファイル名/フォルダ名を検証（区切り文字・相対参照・制御文字・予約名を禁止）
+func validateName(name string) error {
+	if name == "" || name == "." || name == ".." || name == ".keep" {
+		return errInvalidName
+	}
+	if len(name) > 255 || !utf8.ValidString(name) {
+		return errInvalidName
+	}
+	if strings.ContainsAny(name, "/\\") {
+		return errInvalidName
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return errInvalidName
+		}
+	}
+	return nil
+}
+
+// 仮想ディレクトリパスを検証（空はルートディレクトリとして許可）
+func validatePath(virtualPath string) error {
+	virtualPath = strings.Trim(virtualPath, "/")
+	if virtualPath == "" {
+		return nil
+	}
+	if strings.Contains(virtualPath, "\\") {
+		return errInvalidPath
+	}
+	for _, segment := range strings.Split(virtualPath, "/") {
+		if err := validateName(segment); err != nil {
+			return errInvalidPath
+		}
+	}
+	return nil
+}
+
+// 構築済みのオブジェクトキーの長さを検証
+func validateObjectKey(key string) error {
+	if len(key) > maxObjectKeyLength {
+		return errKeyTooLong
+	}
+	return nil
+}
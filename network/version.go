@@ -0,0 +1,115 @@
+package network
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/USlayout/go-minio/storage"
+)
+
+// バージョン履歴ハンドラー（GET: 一覧取得、DELETE: 特定バージョンの削除）
+func handleVersions(w http.ResponseWriter, r *http.Request) {
+	// CORS設定
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 認証済みユーザーIDを取得
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		http.Error(w, "User ID not found in token", http.StatusBadRequest)
+		return
+	}
+
+	path := r.URL.Query().Get("path")
+	filename := r.URL.Query().Get("filename")
+
+	if filename == "" {
+		http.Error(w, "Missing filename parameter", http.StatusBadRequest)
+		return
+	}
+
+	// オブジェクトキーを構築
+	objectKey := buildObjectKey(userID, path, filename)
+
+	if r.Method == http.MethodDelete {
+		versionID := r.URL.Query().Get("versionId")
+		if versionID == "" {
+			http.Error(w, "Missing versionId parameter", http.StatusBadRequest)
+			return
+		}
+
+		err := storage.DeleteVersion(objectKey, versionID)
+		if err != nil {
+			http.Error(w, "Failed to delete version: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"name":      objectKey,
+			"versionId": versionID,
+			"deleted":   true,
+		})
+		return
+	}
+
+	versions, err := storage.ListVersions(objectKey)
+	if err != nil {
+		http.Error(w, "Failed to list versions: "+err.Error(), storageErrorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":       objectKey,
+		"versioning": storage.VersioningEnabled,
+		"versions":   versions,
+	})
+}
+
+// 過去のバージョンを最新として復元するハンドラー
+func handleVersionRestore(w http.ResponseWriter, r *http.Request) {
+	// CORS設定
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 認証済みユーザーIDを取得
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		http.Error(w, "User ID not found in token", http.StatusBadRequest)
+		return
+	}
+
+	path := r.FormValue("path")
+	filename := r.FormValue("filename")
+	versionID := r.FormValue("versionId")
+
+	if filename == "" || versionID == "" {
+		http.Error(w, "Missing filename or versionId parameter", http.StatusBadRequest)
+		return
+	}
+
+	// オブジェクトキーを構築
+	objectKey := buildObjectKey(userID, path, filename)
+
+	version, err := storage.RestoreVersion(objectKey, versionID)
+	if err != nil {
+		http.Error(w, "Failed to restore version: "+err.Error(), storageErrorStatus(err))
+		return
+	}
+	recordActivity(userID, userID, "restore-version", path, filename, false, "")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":         objectKey,
+		"restoredFrom": versionID,
+		"current":      version,
+	})
+}
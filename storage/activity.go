@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// スペースごとに保持するアクティビティ件数（ACTIVITY_LIMIT、既定1000）
+	ActivityLimit = envInt64("ACTIVITY_LIMIT", 1000)
+	// ユーザーごとに保持する最近使ったファイルの件数（RECENT_LIMIT、既定50）
+	RecentLimit = envInt64("RECENT_LIMIT", 50)
+)
+
+// ファイル/フォルダに対する操作の記録
+type ActivityEvent struct {
+	Space       string    `json:"space"` // 個人スペースの場合は ""
+	Path        string    `json:"path"`
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`   // "file" / "folder"
+	Action      string    `json:"action"` // "upload" / "mkdir" / "delete" / "trash" / "restore" / "move" / "rename" / "copy" / "restore-version" / "tag" / "extract"
+	Actor       string    `json:"actor"`
+	Destination string    `json:"destination,omitempty"` // 移動・名前変更先（スペース内のパス）
+	At          time.Time `json:"at"`
+}
+
+// 最近使ったファイル
+type RecentItem struct {
+	Space  string    `json:"space"` // 個人スペースの場合は ""
+	Path   string    `json:"path"`
+	Name   string    `json:"name"`
+	Action string    `json:"action"` // "upload" / "download"
+	At     time.Time `json:"at"`
+}
+
+var (
+	// アクティビティはスペース（"user123" / "teams/dev"）単位で保存
+	activityLog = newBufferedLog[ActivityEvent]("activity/", int(ActivityLimit))
+	recentLog   = newBufferedLog[RecentItem]("recent/", int(RecentLimit))
+)
+
+// スペースのルートプレフィックスからスペース名を取得（個人スペースは ""）
+func spaceOfRoot(root string) string {
+	root = strings.Trim(root, "/")
+	if group, ok := strings.CutPrefix(root, "teams/"); ok {
+		return group
+	}
+	return ""
+}
+
+// スペースでの操作を記録（失敗しても操作自体には影響させない）
+func RecordActivity(root string, event ActivityEvent) {
+	event.Space = spaceOfRoot(root)
+	event.Path = strings.Trim(event.Path, "/")
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+	if err := activityLog.prepend(strings.Trim(root, "/"), event, nil); err != nil {
+		log.Printf("Failed to record activity: %v", err)
+	}
+}
+
+// 最近使ったファイルに追加（同じファイルは最新の1件のみ保持）
+func RecordRecent(userID string, item RecentItem) {
+	item.Path = strings.Trim(item.Path, "/")
+	if item.At.IsZero() {
+		item.At = time.Now()
+	}
+	err := recentLog.prepend(userID, item, func(existing RecentItem) bool {
+		return existing.Space == item.Space && existing.Path == item.Path && existing.Name == item.Name
+	})
+	if err != nil {
+		log.Printf("Failed to record recent file: %v", err)
+	}
+}
+
+// 最近使ったファイルを取得（新しい順）
+func ListRecent(userID string, limit int) ([]RecentItem, error) {
+	items, err := recentLog.get(userID)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	if items == nil {
+		items = []RecentItem{}
+	}
+	return items, nil
+}
+
+// 複数スペースのアクティビティを新しい順にまとめて取得（before より前のもののみ）
+func ListActivity(roots []string, before time.Time, limit int) ([]ActivityEvent, error) {
+	events := []ActivityEvent{}
+	for _, root := range roots {
+		items, err := activityLog.get(strings.Trim(root, "/"))
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range items {
+			if before.IsZero() || event.At.Before(before) {
+				events = append(events, event)
+			}
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].At.After(events[j].At)
+	})
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"context"
+	"mime"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// フォルダ統計キャッシュの有効期限（他クライアントによる変更を取りこぼした場合の保険）
+	folderStatsTTL = 10 * time.Minute
+	// キャッシュするフォルダ数の上限（超えた場合は全て破棄）
+	maxCachedFolders = 10000
+)
+
+// フォルダ配下（サブフォルダを含む）の集計情報
+type FolderStats struct {
+	Size         int64     `json:"size"`
+	FileCount    int       `json:"fileCount"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// 使用量の内訳（トップレベルフォルダ別・コンテンツタイプ別）
+type UsageBreakdown struct {
+	Root          string             `json:"root"`
+	Total         FolderStats        `json:"total"`
+	ByFolder      []FolderUsage      `json:"byFolder"`
+	ByContentType []ContentTypeUsage `json:"byContentType"`
+}
+
+type FolderUsage struct {
+	Name string `json:"name"` // ルート直下のファイルは ""
+	FolderStats
+}
+
+type ContentTypeUsage struct {
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	FileCount   int    `json:"fileCount"`
+}
+
+type cachedFolderStats struct {
+	stats   FolderStats
+	expires time.Time
+}
+
+var (
+	folderStatsMu    sync.Mutex
+	folderStatsCache = map[string]*cachedFolderStats{}
+)
+
+// オブジェクトの追加・削除を使用量とフォルダ統計に反映
+func recordChange(key string, bytes, objects int64) {
+	trackUsage(key, bytes, objects)
+	updateFolderStats(key, bytes, objects)
+}
+
+// キーの親フォルダのプレフィックスを浅い順に返す（"a/b/c.txt" → "a/", "a/b/"）
+func ancestorPrefixes(key string) []string {
+	parts := strings.Split(key, "/")
+	prefixes := make([]string, 0, len(parts)-1)
+	current := ""
+	for _, part := range parts[:len(parts)-1] {
+		current += part + "/"
+		prefixes = append(prefixes, current)
+	}
+	return prefixes
+}
+
+// キャッシュ済みの親フォルダの統計を差分更新
+// 削除の場合は最終更新日時を再計算できないため、キャッシュを破棄する
+func updateFolderStats(key string, bytes, objects int64) {
+	folderStatsMu.Lock()
+	defer folderStatsMu.Unlock()
+
+	isKeep := path.Base(key) == ".keep"
+	now := time.Now()
+
+	for _, prefix := range ancestorPrefixes(key) {
+		cached := folderStatsCache[prefix]
+		if cached == nil {
+			continue
+		}
+		if objects < 0 {
+			delete(folderStatsCache, prefix)
+			continue
+		}
+		cached.stats.Size += bytes
+		if !isKeep {
+			cached.stats.FileCount += int(objects)
+		}
+		cached.stats.LastModified = now
+	}
+}
+
+// フォルダ配下の統計を取得（キャッシュがなければ走査し、サブフォルダ分もまとめてキャッシュ）
+func GetFolderStats(prefix string) (FolderStats, error) {
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+
+	folderStatsMu.Lock()
+	cached := folderStatsCache[prefix]
+	if cached != nil && time.Now().Before(cached.expires) {
+		stats := cached.stats
+		folderStatsMu.Unlock()
+		return stats, nil
+	}
+	folderStatsMu.Unlock()
+
+	all, err := scanFolderStats(prefix)
+	if err != nil {
+		return FolderStats{}, err
+	}
+	return all[prefix], nil
+}
+
+// プレフィックス配下を再帰的に走査し、全サブフォルダの統計を計算してキャッシュ
+func scanFolderStats(prefix string) (map[string]FolderStats, error) {
+	objects, err := listAllObjects(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	all := map[string]*FolderStats{prefix: {}}
+	for _, object := range objects {
+		rel := strings.TrimPrefix(object.Key, prefix)
+		targets := []string{prefix}
+		for _, sub := range ancestorPrefixes(rel) {
+			targets = append(targets, prefix+sub)
+		}
+
+		for _, target := range targets {
+			stats := all[target]
+			if stats == nil {
+				stats = &FolderStats{}
+				all[target] = stats
+			}
+			if path.Base(object.Key) != ".keep" {
+				stats.Size += object.Size
+				stats.FileCount++
+			}
+			if object.LastModified.After(stats.LastModified) {
+				stats.LastModified = object.LastModified
+			}
+		}
+	}
+
+	result := make(map[string]FolderStats, len(all))
+	expires := time.Now().Add(folderStatsTTL)
+
+	folderStatsMu.Lock()
+	if len(folderStatsCache)+len(all) > maxCachedFolders {
+		folderStatsCache = map[string]*cachedFolderStats{}
+	}
+	for key, stats := range all {
+		result[key] = *stats
+		if len(folderStatsCache) < maxCachedFolders {
+			folderStatsCache[key] = &cachedFolderStats{stats: *stats, expires: expires}
+		}
+	}
+	folderStatsMu.Unlock()
+
+	return result, nil
+}
+
+// ルート配下の使用量をトップレベルフォルダ別・コンテンツタイプ別に集計
+func GetUsageBreakdown(root string) (*UsageBreakdown, error) {
+	root = strings.TrimSuffix(root, "/") + "/"
+
+	objectCh := client.ListObjects(context.Background(), bucketName, minio.ListObjectsOptions{
+		Prefix:       root,
+		Recursive:    true,
+		WithMetadata: true, // コンテンツタイプを取得するため
+	})
+
+	breakdown := &UsageBreakdown{Root: root}
+	byFolder := map[string]*FolderUsage{}
+	byType := map[string]*ContentTypeUsage{}
+
+	for object := range objectCh {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		object = logicalObject(object)
+
+		rel := strings.TrimPrefix(object.Key, root)
+		folder := ""
+		if i := strings.Index(rel, "/"); i >= 0 {
+			folder = rel[:i]
+		}
+		if byFolder[folder] == nil {
+			byFolder[folder] = &FolderUsage{Name: folder}
+		}
+		fu := byFolder[folder]
+		if object.LastModified.After(fu.LastModified) {
+			fu.LastModified = object.LastModified
+		}
+		if object.LastModified.After(breakdown.Total.LastModified) {
+			breakdown.Total.LastModified = object.LastModified
+		}
+
+		// .keepはフォルダの存在を示すのみのため件数・サイズに含めない
+		if path.Base(rel) == ".keep" {
+			continue
+		}
+		fu.Size += object.Size
+		fu.FileCount++
+		breakdown.Total.Size += object.Size
+		breakdown.Total.FileCount++
+
+		contentType := objectContentType(object)
+		if byType[contentType] == nil {
+			byType[contentType] = &ContentTypeUsage{ContentType: contentType}
+		}
+		byType[contentType].Size += object.Size
+		byType[contentType].FileCount++
+	}
+
+	breakdown.ByFolder = make([]FolderUsage, 0, len(byFolder))
+	for _, fu := range byFolder {
+		breakdown.ByFolder = append(breakdown.ByFolder, *fu)
+	}
+	sort.Slice(breakdown.ByFolder, func(i, j int) bool {
+		return breakdown.ByFolder[i].Size > breakdown.ByFolder[j].Size
+	})
+
+	breakdown.ByContentType = make([]ContentTypeUsage, 0, len(byType))
+	for _, tu := range byType {
+		breakdown.ByContentType = append(breakdown.ByContentType, *tu)
+	}
+	sort.Slice(breakdown.ByContentType, func(i, j int) bool {
+		return breakdown.ByContentType[i].Size > breakdown.ByContentType[j].Size
+	})
+
+	return breakdown, nil
+}
+
+// 一覧結果のコンテンツタイプ（未設定の場合は拡張子から推測）
+func objectContentType(object minio.ObjectInfo) string {
+	contentType := object.ContentType
+	if contentType == "" {
+		contentType = object.Metadata.Get("Content-Type")
+	}
+	return guessContentType(object.Key, contentType)
+}
+
+// コンテンツタイプを正規化（未設定の場合は拡張子から推測）
+func guessContentType(key, contentType string) string {
+	if contentType == "" || contentType == "application/octet-stream" {
+		if byExt := mime.TypeByExtension(path.Ext(key)); byExt != "" {
+			contentType = byExt
+		}
+	}
+	if contentType == "" {
+		return "application/octet-stream"
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediaType
+	}
+	return contentType
+}
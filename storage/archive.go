@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrTooManyFiles       = errors.New("too many files")
+	ErrArchiveLimit       = errors.New("archive exceeds extraction limits")
+	ErrUnsupportedArchive = errors.New("unsupported archive format")
+)
+
+var (
+	// 一括ダウンロードに含めるファイル数の上限（ARCHIVE_MAX_FILES、既定10000）
+	ArchiveMaxFiles = envInt64("ARCHIVE_MAX_FILES", 10000)
+	// 展開するアーカイブのエントリ数の上限（EXTRACT_MAX_ENTRIES、既定10000）
+	ExtractMaxEntries = envInt64("EXTRACT_MAX_ENTRIES", 10000)
+	// 展開後の合計サイズの上限（EXTRACT_MAX_SIZE、既定10GiB）
+	ExtractMaxSize = envInt64("EXTRACT_MAX_SIZE", 10<<30)
+	// 展開後のサイズと圧縮後のサイズの比の上限（EXTRACT_MAX_RATIO、既定100。zip bomb対策）
+	ExtractMaxRatio = envInt64("EXTRACT_MAX_RATIO", 100)
+)
+
+// フォルダ配下のオブジェクト一覧をキー順に取得（空フォルダの .keep を含む）
+func ListFolderObjects(prefix string) ([]FileInfo, error) {
+	objects, err := listAllObjects(strings.TrimSuffix(prefix, "/") + "/")
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]FileInfo, 0, len(objects))
+	for _, object := range objects {
+		files = append(files, FileInfo{
+			Name:         object.Key,
+			Size:         object.Size,
+			LastModified: object.LastModified,
+			ContentType:  object.ContentType,
+		})
+	}
+	return files, nil
+}
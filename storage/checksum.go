@@ -0,0 +1,299 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"maps"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var (
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	ErrInvalidChecksum  = errors.New("invalid checksum")
+)
+
+// チェックサムを保存するメタデータのキー（x-amz-meta-checksum-*）
+const (
+	checksumMD5Key    = "Checksum-Md5"
+	checksumSHA256Key = "Checksum-Sha256"
+)
+
+// 検証前のアップロードを置く領域（"." で始まるため一覧・検索には出ない）
+const uploadStagingPrefix = ".uploads/"
+
+// 一時領域に残ったオブジェクトを削除するまでの時間（UPLOAD_STAGING_MAX_AGE、既定24時間）
+// 通常は保存後すぐに削除されるが、異常終了や切断の場合は残るため定期的に掃除する
+var UploadStagingMaxAge = envDuration("UPLOAD_STAGING_MAX_AGE", 24*time.Hour)
+
+// 一時領域の掃除の間隔
+const uploadStagingSweepInterval = time.Hour
+
+// オブジェクトのチェックサム（16進数の小文字）
+type Checksums struct {
+	MD5    string `json:"md5,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// 16進数またはBase64のハッシュ値を16進数に正規化
+func normalizeDigest(value string, size int) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+	if len(value) == size*2 {
+		if raw, err := hex.DecodeString(value); err == nil {
+			return hex.EncodeToString(raw), nil
+		}
+	}
+	if raw, err := base64.StdEncoding.DecodeString(value); err == nil && len(raw) == size {
+		return hex.EncodeToString(raw), nil
+	}
+	return "", ErrInvalidChecksum
+}
+
+// クライアントが送信したチェックサム（Content-MD5 / x-checksum-sha256）を解析
+func ParseChecksums(contentMD5, sha256Value string) (Checksums, error) {
+	var sums Checksums
+	var err error
+	if sums.MD5, err = normalizeDigest(contentMD5, md5.Size); err != nil {
+		return Checksums{}, err
+	}
+	if sums.SHA256, err = normalizeDigest(sha256Value, sha256.Size); err != nil {
+		return Checksums{}, err
+	}
+	return sums, nil
+}
+
+// 期待値と一致するか確認（期待値が空の項目は確認しない）
+func (c Checksums) verify(expected Checksums) error {
+	if expected.MD5 != "" && expected.MD5 != c.MD5 {
+		return fmt.Errorf("%w: md5 does not match", ErrChecksumMismatch)
+	}
+	if expected.SHA256 != "" && expected.SHA256 != c.SHA256 {
+		return fmt.Errorf("%w: sha256 does not match", ErrChecksumMismatch)
+	}
+	return nil
+}
+
+// SHA-256のBase64表現（Repr-Digest / Digest ヘッダー用）
+func (c Checksums) SHA256Base64() string {
+	raw, err := hex.DecodeString(c.SHA256)
+	if err != nil || len(raw) != sha256.Size {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+// チェックサムを付加したユーザー定義メタデータ
+func (c Checksums) withMetadata(metadata map[string]string) map[string]string {
+	merged := maps.Clone(metadata)
+	if merged == nil {
+		merged = map[string]string{}
+	}
+	// 記録されていない値（複製元に保存されていない場合など）は保存しない
+	if c.MD5 != "" {
+		merged[checksumMD5Key] = c.MD5
+	}
+	if c.SHA256 != "" {
+		merged[checksumSHA256Key] = c.SHA256
+	}
+	return merged
+}
+
+// ユーザー定義メタデータから保存済みのチェックサムを取得（未保存の場合は nil）
+func checksumsFromMetadata(metadata map[string]string) *Checksums {
+	sums := &Checksums{
+		MD5:    metadata[checksumMD5Key],
+		SHA256: metadata[checksumSHA256Key],
+	}
+	if sums.MD5 == "" && sums.SHA256 == "" {
+		return nil
+	}
+	return sums
+}
+
+// 書き込まれたデータのMD5とSHA-256を同時に計算
+type checksumHasher struct {
+	md5    hash.Hash
+	sha256 hash.Hash
+	size   int64
+}
+
+func newChecksumHasher() *checksumHasher {
+	return &checksumHasher{md5: md5.New(), sha256: sha256.New()}
+}
+
+func (h *checksumHasher) Write(p []byte) (int, error) {
+	h.md5.Write(p)
+	h.sha256.Write(p)
+	h.size += int64(len(p))
+	return len(p), nil
+}
+
+func (h *checksumHasher) Sum() Checksums {
+	return Checksums{
+		MD5:    hex.EncodeToString(h.md5.Sum(nil)),
+		SHA256: hex.EncodeToString(h.sha256.Sum(nil)),
+	}
+}
+
+// 読み取り位置を戻せるデータのチェックサムとサイズを計算し、先頭に戻す
+func computeChecksums(data io.ReadSeeker) (Checksums, int64, error) {
+	h := newChecksumHasher()
+	n, err := io.Copy(h, data)
+	if err != nil {
+		return Checksums{}, 0, err
+	}
+	if _, err := data.Seek(0, io.SeekStart); err != nil {
+		return Checksums{}, 0, err
+	}
+	return h.Sum(), n, nil
+}
+
+// 読み取り位置を戻せるデータがクライアントの申告したチェックサムと一致するか確認
+func VerifyChecksums(data io.ReadSeeker, expected Checksums) error {
+	if expected == (Checksums{}) {
+		return nil
+	}
+	sums, _, err := computeChecksums(data)
+	if err != nil {
+		return err
+	}
+	return sums.verify(expected)
+}
+
+// 読み直せないデータを一時領域に保存しながらチェックサムを計算し、
+// 検証に成功した場合のみ本来のキーへ複製する（検証前のデータは公開されない）
+func putStaged(ctx context.Context, filename string, data io.Reader, size int64, opts UploadOptions) (minio.UploadInfo, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return minio.UploadInfo{}, err
+	}
+	stagingKey := uploadStagingPrefix + hex.EncodeToString(buf)
+
+	// 暗号化フォルダの場合は一時領域にも暗号文のみを置く
+	h := newChecksumHasher()
+	var body io.Reader = io.TeeReader(data, h)
+	var sealer *envelopeSealer
+	compressed := false
+	// 一時領域も保存先の所有者の鍵で暗号化する
+	putOpts := minio.PutObjectOptions{ServerSideEncryption: writeSSE(filename)}
+	switch {
+	case isEnvelopePath(filename):
+		var err error
+		if sealer, err = newEnvelopeSealer(body); err != nil {
+			return minio.UploadInfo{}, err
+		}
+		body = sealer
+		if size >= 0 {
+			size = envelopeCipherSize(size)
+		}
+	case isCompressible(opts.ContentType, size):
+		compressor := newCompressReader(body)
+		defer compressor.Close()
+		body, size, compressed = compressor, -1, true
+		putOpts.PartSize = compressPartSize
+	}
+
+	staged, err := client.PutObject(ctx, bucketName, stagingKey, body, size, putOpts)
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	defer client.RemoveObject(ctx, bucketName, stagingKey, minio.RemoveObjectOptions{VersionID: staged.VersionID})
+
+	sums := h.Sum()
+	if err := sums.verify(opts.Checksums); err != nil {
+		return minio.UploadInfo{}, err
+	}
+
+	metadata := sums.withMetadata(opts.Metadata)
+	if sealer != nil {
+		metadata = sealer.withMetadata(metadata, sealer.size)
+	}
+	if compressed {
+		metadata = compressionMetadata(metadata, h.size)
+	}
+	dst := minio.CopyDestOptions{
+		Bucket:          bucketName,
+		Object:          filename,
+		UserMetadata:    metadata,
+		ReplaceMetadata: true,
+		UserTags:        opts.Tags,
+		ReplaceTags:     len(opts.Tags) > 0,
+		ContentType:     opts.ContentType,
+		Encryption:      writeSSE(filename),
+	}
+	src := minio.CopySrcOptions{
+		Bucket:     bucketName,
+		Object:     stagingKey,
+		VersionID:  staged.VersionID,
+		Encryption: copySourceSSE(writeSSE(filename)),
+	}
+	var info minio.UploadInfo
+	if staged.Size > maxCopyObjectSize {
+		info, err = client.ComposeObject(ctx, dst, src)
+	} else {
+		info, err = client.CopyObject(ctx, dst, src)
+	}
+	if err != nil {
+		return info, err
+	}
+	info.Size = h.size
+	return info, nil
+}
+
+// 一時領域に残った古いオブジェクトを削除し、削除した数を返す
+func SweepUploadStaging() (int, error) {
+	ctx := context.Background()
+	cutoff := time.Now().Add(-UploadStagingMaxAge)
+	removed := 0
+
+	objectCh := client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Prefix:       uploadStagingPrefix,
+		Recursive:    true,
+		WithVersions: VersioningEnabled,
+	})
+	for object := range objectCh {
+		if object.Err != nil {
+			return removed, object.Err
+		}
+		if !object.LastModified.Before(cutoff) {
+			continue
+		}
+		if err := client.RemoveObject(ctx, bucketName, object.Key, minio.RemoveObjectOptions{VersionID: object.VersionID}); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// 一時領域を定期的に掃除するバックグラウンド処理を開始
+func StartUploadStagingSweeper() {
+	go func() {
+		ticker := time.NewTicker(uploadStagingSweepInterval)
+		defer ticker.Stop()
+
+		for {
+			removed, err := SweepUploadStaging()
+			if err != nil {
+				log.Printf("Upload staging sweep error: %v", err)
+			} else if removed > 0 {
+				log.Printf("Removed %d stale upload staging objects", removed)
+			}
+			<-ticker.C
+		}
+	}()
+}
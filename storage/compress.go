@@ -0,0 +1,323 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/minio/minio-go/v7"
+)
+
+var ErrCompressionCorrupted = errors.New("compressed object is corrupted")
+
+var (
+	// 圧縮しやすいコンテンツタイプを保存時に圧縮するか（COMPRESSION_ENABLED、既定false）
+	CompressionEnabled = envBool("COMPRESSION_ENABLED", false)
+	// 圧縮するコンテンツタイプ（COMPRESSION_TYPES、カンマ区切り・"text/*" 形式も可）
+	CompressionTypes = splitEnvList(envString("COMPRESSION_TYPES",
+		"text/*,application/json,application/x-ndjson,application/xml,application/javascript,application/yaml,application/x-yaml,image/svg+xml"))
+	// 圧縮する最小サイズ（COMPRESSION_MIN_SIZE、既定4KiB）
+	CompressionMinSize = envInt64("COMPRESSION_MIN_SIZE", 4<<10)
+)
+
+// 圧縮の情報を保存するメタデータのキー（x-amz-meta-compression*）
+const (
+	compressionKey      = "Compression"       // 圧縮方式（"zstd"）
+	compressionSizeKey  = "Compression-Size"  // 圧縮前のサイズ
+	compressionFrameKey = "Compression-Frame" // フレームの単位（圧縮前のバイト数）
+)
+
+// 独立して展開できるフレームの単位（圧縮前のバイト数）。範囲読み取りはこの単位で展開する
+const compressFrameSize = 1 << 20
+
+// zstdのシーク可能な形式（末尾のスキップ可能フレームに各フレームのサイズの表を置く）
+// 表はスキップ可能フレームのため、通常のzstdとしても全体を展開できる
+const (
+	seekTableMagic       = 0x184D2A5E
+	seekTableFooterMagic = 0x8F92EAB1
+	seekTableHeaderSize  = 8 // スキップ可能フレームのマジックナンバーとサイズ
+	seekTableEntrySize   = 8 // 圧縮後と圧縮前のサイズ
+	seekTableFooterSize  = 9 // フレーム数・記述子・マジックナンバー
+)
+
+// 圧縮後のサイズは事前に分からないため、マルチパートの1パートのサイズを固定する
+// （未指定の場合は最大サイズから逆算した巨大なバッファが確保される）
+const compressPartSize = 16 << 20
+
+// 保存時に圧縮する対象か（size が -1 の場合はサイズを問わない）
+func isCompressible(contentType string, size int64) bool {
+	if !CompressionEnabled || (size >= 0 && size < max(CompressionMinSize, 1)) {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	mediaType = strings.ToLower(mediaType)
+	for _, pattern := range CompressionTypes {
+		if matchContentType(mediaType, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// 圧縮の情報を付加したユーザー定義メタデータ（コンテンツタイプは元のまま保存する）
+func compressionMetadata(metadata map[string]string, size int64) map[string]string {
+	merged := maps.Clone(metadata)
+	if merged == nil {
+		merged = map[string]string{}
+	}
+	merged[compressionKey] = "zstd"
+	merged[compressionSizeKey] = strconv.FormatInt(size, 10)
+	merged[compressionFrameKey] = strconv.Itoa(compressFrameSize)
+	return merged
+}
+
+// メタデータに記録された圧縮前のサイズ（圧縮されていない場合は false）
+func compressedSize(metadata map[string]string) (int64, bool) {
+	if !strings.EqualFold(metadata[compressionKey], "zstd") {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(metadata[compressionSizeKey], 10, 64)
+	if err != nil || size < 0 {
+		return 0, false
+	}
+	return size, true
+}
+
+// データを圧縮しながら読み出すReader（途中で読み出しをやめた場合は Close すること）
+func newCompressReader(data io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeSeekableZstd(pw, data))
+	}()
+	return pr
+}
+
+// compressFrameSize ごとに独立したフレームとして圧縮し、末尾にシーク用の表を書き込む
+func writeSeekableZstd(w io.Writer, data io.Reader) error {
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return err
+	}
+	defer enc.Close()
+
+	plain := make([]byte, compressFrameSize)
+	var frame, table []byte
+	frames := 0
+	for {
+		n, err := io.ReadFull(data, plain)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		if n > 0 {
+			frame = enc.EncodeAll(plain[:n], frame[:0])
+			if _, err := w.Write(frame); err != nil {
+				return err
+			}
+			table = binary.LittleEndian.AppendUint32(table, uint32(len(frame)))
+			table = binary.LittleEndian.AppendUint32(table, uint32(n))
+			frames++
+		}
+		if n < len(plain) {
+			break
+		}
+	}
+
+	seekTable := binary.LittleEndian.AppendUint32(nil, seekTableMagic)
+	seekTable = binary.LittleEndian.AppendUint32(seekTable, uint32(len(table)+seekTableFooterSize))
+	seekTable = append(seekTable, table...)
+	seekTable = binary.LittleEndian.AppendUint32(seekTable, uint32(frames))
+	seekTable = append(seekTable, 0) // フレームごとのチェックサムなし
+	seekTable = binary.LittleEndian.AppendUint32(seekTable, seekTableFooterMagic)
+	_, err = w.Write(seekTable)
+	return err
+}
+
+// 圧縮して保存
+func putCompressed(ctx context.Context, filename string, data io.Reader, size int64, sums Checksums, opts UploadOptions) (minio.UploadInfo, error) {
+	body := newCompressReader(data)
+	defer body.Close()
+
+	info, err := client.PutObject(ctx, bucketName, filename, body, -1, minio.PutObjectOptions{
+		UserMetadata:         compressionMetadata(sums.withMetadata(opts.Metadata), size),
+		UserTags:             opts.Tags,
+		ContentType:          opts.ContentType,
+		PartSize:             compressPartSize,
+		ServerSideEncryption: writeSSE(filename),
+	})
+	if err != nil {
+		return info, err
+	}
+	info.Size = size
+	return info, nil
+}
+
+// シーク可能な形式で圧縮されたオブジェクトを展開しながら読み取る（フレーム単位で任意の位置から読める）
+type frameDecompressReader struct {
+	src     io.ReadSeekCloser
+	dec     *zstd.Decoder
+	size    int64
+	pos     int64
+	srcPos  int64   // src の読み取り位置（続きのフレームはシークせずに読む）
+	offsets []int64 // 各フレームの圧縮後の開始位置（末尾は表の開始位置）
+	starts  []int64 // 各フレームの圧縮前の開始位置（末尾は全体のサイズ）
+	index   int     // 展開済みのフレーム（-1: なし）
+	frame   []byte
+	buf     []byte
+}
+
+func (r *frameDecompressReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	if r.starts == nil {
+		if err := r.loadSeekTable(); err != nil {
+			return 0, err
+		}
+	}
+	index := sort.Search(len(r.starts)-1, func(i int) bool {
+		return r.starts[i+1] > r.pos
+	})
+	if index != r.index {
+		if err := r.decodeFrame(index); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.frame[r.pos-r.starts[index]:])
+	r.pos += int64(n)
+	return n, nil
+}
+
+// src の指定した位置から読み取る
+func (r *frameDecompressReader) readAt(p []byte, offset int64) error {
+	if offset != r.srcPos {
+		if _, err := r.src.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	n, err := io.ReadFull(r.src, p)
+	r.srcPos = offset + int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// 末尾のシーク用の表を読み込む
+func (r *frameDecompressReader) loadSeekTable() error {
+	end, err := r.src.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	r.srcPos = end
+	if end < seekTableHeaderSize+seekTableFooterSize {
+		return fmt.Errorf("%w: seek table is missing", ErrCompressionCorrupted)
+	}
+	footer := make([]byte, seekTableFooterSize)
+	if err := r.readAt(footer, end-seekTableFooterSize); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(footer[5:]) != seekTableFooterMagic || footer[4] != 0 {
+		return fmt.Errorf("%w: seek table is missing", ErrCompressionCorrupted)
+	}
+
+	frames := int64(binary.LittleEndian.Uint32(footer))
+	tableStart := end - seekTableHeaderSize - frames*seekTableEntrySize - seekTableFooterSize
+	if tableStart < 0 {
+		return fmt.Errorf("%w: invalid seek table", ErrCompressionCorrupted)
+	}
+	table := make([]byte, seekTableHeaderSize+frames*seekTableEntrySize)
+	if err := r.readAt(table, tableStart); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(table) != seekTableMagic ||
+		int64(binary.LittleEndian.Uint32(table[4:])) != frames*seekTableEntrySize+seekTableFooterSize {
+		return fmt.Errorf("%w: invalid seek table", ErrCompressionCorrupted)
+	}
+
+	offsets := make([]int64, 0, frames+1)
+	starts := make([]int64, 0, frames+1)
+	var offset, start int64
+	for entry := table[seekTableHeaderSize:]; len(entry) > 0; entry = entry[seekTableEntrySize:] {
+		offsets, starts = append(offsets, offset), append(starts, start)
+		offset += int64(binary.LittleEndian.Uint32(entry))
+		start += int64(binary.LittleEndian.Uint32(entry[4:]))
+	}
+	// 表の合計が実際のサイズと一致しない場合は壊れている
+	if offset != tableStart || start != r.size {
+		return fmt.Errorf("%w: seek table does not match the object", ErrCompressionCorrupted)
+	}
+	r.offsets, r.starts = append(offsets, offset), append(starts, start)
+	return nil
+}
+
+// 指定したフレームのみを範囲読み取りして展開
+func (r *frameDecompressReader) decodeFrame(index int) error {
+	length := r.offsets[index+1] - r.offsets[index]
+	plainSize := r.starts[index+1] - r.starts[index]
+	// 圧縮できないデータでも圧縮後のサイズは元の2倍を超えない
+	if plainSize > compressFrameSize || length > 2*compressFrameSize {
+		return fmt.Errorf("%w: frame %d is too large", ErrCompressionCorrupted, index)
+	}
+	if int64(cap(r.buf)) < length {
+		r.buf = make([]byte, length)
+	}
+	if err := r.readAt(r.buf[:length], r.offsets[index]); err != nil {
+		return err
+	}
+
+	frame, err := r.dec.DecodeAll(r.buf[:length], r.frame[:0])
+	if err != nil || int64(len(frame)) != plainSize {
+		r.index = -1
+		return fmt.Errorf("%w: frame %d", ErrCompressionCorrupted, index)
+	}
+	r.frame, r.index = frame, index
+	return nil
+}
+
+func (r *frameDecompressReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *frameDecompressReader) Close() error {
+	r.dec.Close()
+	return r.src.Close()
+}
+
+// 圧縮されている場合は展開するReaderに置き換え、サイズを圧縮前のサイズにする
+func decompressObject(src io.ReadSeekCloser, info minio.ObjectInfo) (io.ReadSeekCloser, minio.ObjectInfo, error) {
+	size, ok := compressedSize(info.UserMetadata)
+	if !ok {
+		return src, info, nil
+	}
+	info.Size = size
+	// フレームは compressFrameSize 以下のため、それを超えるメモリは確保しない
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(2*compressFrameSize))
+	if err != nil {
+		return nil, info, err
+	}
+	return &frameDecompressReader{src: src, dec: dec, size: size, index: -1}, info, nil
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// 圧縮しやすいが単調ではない試験データ
+func compressibleBytes(n int) []byte {
+	rng := rand.New(rand.NewPCG(1, 2))
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = "abcdefgh \n"[rng.IntN(10)]
+	}
+	return data
+}
+
+// 圧縮したデータを展開するReader
+func openCompressed(t *testing.T, plain []byte) io.ReadSeekCloser {
+	t.Helper()
+	var compressed bytes.Buffer
+	if err := writeSeekableZstd(&compressed, bytes.NewReader(plain)); err != nil {
+		t.Fatal(err)
+	}
+	info := minio.ObjectInfo{UserMetadata: compressionMetadata(nil, int64(len(plain)))}
+	reader, info, err := decompressObject(nopReaderAt{bytes.NewReader(compressed.Bytes())}, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len(plain)) {
+		t.Fatalf("size = %d, want %d", info.Size, len(plain))
+	}
+	return reader
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"small", 100},
+		{"exactly one frame", compressFrameSize},
+		{"several frames", 2*compressFrameSize + 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain := compressibleBytes(tt.size)
+			got, err := io.ReadAll(openCompressed(t, plain))
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Fatal("decompressed content does not match")
+			}
+		})
+	}
+}
+
+func TestCompressServeContentRange(t *testing.T) {
+	size := 2*compressFrameSize + 100
+	plain := compressibleBytes(size)
+
+	// /download と同じく http.ServeContent で範囲指定に応答する（フレームの境界をまたぐ）
+	start, end := compressFrameSize-10, compressFrameSize+20
+	req := httptest.NewRequest(http.MethodGet, "/download", nil)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	rec := httptest.NewRecorder()
+	http.ServeContent(rec, req, "file.log", time.Time{}, openCompressed(t, plain))
+
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusPartialContent)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), plain[start:end+1]) {
+		t.Fatal("ranged content does not match")
+	}
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// 環境変数から期間を読み込む（例: "720h"）。未設定・不正値の場合は既定値
+func envDuration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid %s=%q, using default %v", key, value, def)
+		return def
+	}
+	return d
+}
+
+// 環境変数から真偽値を読み込む。未設定・不正値の場合は既定値
+func envBool(key string, def bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid %s=%q, using default %v", key, value, def)
+		return def
+	}
+	return b
+}
+
+// 環境変数から整数を読み込む。未設定・不正値の場合は既定値
+func envInt64(key string, def int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		log.Printf("Invalid %s=%q, using default %v", key, value, def)
+		return def
+	}
+	return n
+}
+
+// 環境変数から文字列を読み込む。未設定の場合は既定値
+func envString(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var (
+	ErrContentTypeNotAllowed = errors.New("content type is not allowed")
+	ErrBackfillRunning       = errors.New("content type backfill is already running")
+)
+
+var (
+	// アップロードを許可するコンテンツタイプ（UPLOAD_ALLOWED_TYPES、カンマ区切り・"image/*" 形式も可、未設定の場合はすべて許可）
+	UploadAllowedTypes = splitEnvList(os.Getenv("UPLOAD_ALLOWED_TYPES"))
+	// アップロードを拒否するコンテンツタイプ（UPLOAD_DENIED_TYPES、許可リストより優先）
+	UploadDeniedTypes = splitEnvList(os.Getenv("UPLOAD_DENIED_TYPES"))
+)
+
+// 判定に使う先頭バイト数（http.DetectContentType と同じ）
+const sniffLength = 512
+
+// カンマ区切りの環境変数を小文字のリストに分割
+func splitEnvList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// コンテンツタイプがパターンに一致するか判定（"image/*" は前方一致）
+func matchContentType(contentType, pattern string) bool {
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(contentType, strings.TrimSuffix(pattern, "*"))
+	}
+	return contentType == pattern
+}
+
+// 先頭バイトからの判定結果が拡張子・申告値より信頼できるか
+// （テキストやZIPはOffice文書等と区別できないため拡張子を優先する）
+func isDistinctiveSniff(mediaType string) bool {
+	switch mediaType {
+	case "application/octet-stream", "application/zip", "text/plain", "text/html", "text/xml":
+		return false
+	}
+	return true
+}
+
+// ファイル名・クライアントの申告値・先頭バイトからコンテンツタイプを判定
+// 画像やPDFなど先頭バイトで識別できる形式はそれを優先し、拡張子の偽装を防ぐ
+func DetectContentType(filename, declared string, head []byte) string {
+	sniffed := ""
+	if len(head) > 0 {
+		sniffed = http.DetectContentType(head)
+		if mediaType, _, err := mime.ParseMediaType(sniffed); err == nil && isDistinctiveSniff(mediaType) {
+			return sniffed
+		}
+	}
+
+	if byExt := mime.TypeByExtension(strings.ToLower(path.Ext(filename))); byExt != "" {
+		return byExt
+	}
+	if mediaType, _, err := mime.ParseMediaType(declared); err == nil && mediaType != "application/octet-stream" {
+		return declared
+	}
+	if sniffed != "" {
+		return sniffed
+	}
+	return "application/octet-stream"
+}
+
+// 先頭バイトを読み取ってコンテンツタイプを判定し、読み取り位置を先頭に戻す
+func SniffContentType(filename, declared string, data io.ReadSeeker) (string, error) {
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(data, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := data.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return DetectContentType(filename, declared, head[:n]), nil
+}
+
+// アップロードポリシーでコンテンツタイプが許可されているか確認
+func CheckContentType(contentType string) error {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "application/octet-stream"
+	}
+	mediaType = strings.ToLower(mediaType)
+
+	for _, pattern := range UploadDeniedTypes {
+		if matchContentType(mediaType, pattern) {
+			return fmt.Errorf("%w: %s", ErrContentTypeNotAllowed, mediaType)
+		}
+	}
+	if len(UploadAllowedTypes) == 0 {
+		return nil
+	}
+	for _, pattern := range UploadAllowedTypes {
+		if matchContentType(mediaType, pattern) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrContentTypeNotAllowed, mediaType)
+}
+
+// コンテンツタイプ補完ジョブの進捗
+type BackfillStatus struct {
+	Running    bool       `json:"running"`
+	DryRun     bool       `json:"dryRun"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Scanned    int64      `json:"scanned"`
+	Updated    int64      `json:"updated"`
+	Failed     int64      `json:"failed"`
+	Errors     []string   `json:"errors,omitempty"`
+}
+
+// 進捗に保持するエラーの上限
+const maxBackfillErrors = 100
+
+var (
+	backfillMu     sync.Mutex
+	backfillStatus BackfillStatus
+)
+
+// コンテンツタイプ補完ジョブの進捗を取得
+func GetBackfillStatus() BackfillStatus {
+	backfillMu.Lock()
+	defer backfillMu.Unlock()
+	status := backfillStatus
+	status.Errors = append([]string(nil), backfillStatus.Errors...)
+	return status
+}
+
+// コンテンツタイプ未設定の既存オブジェクトを判定し直すジョブを開始
+// dryRun の場合は更新対象の件数のみ数える
+func StartContentTypeBackfill(dryRun bool) (BackfillStatus, error) {
+	backfillMu.Lock()
+	if backfillStatus.Running {
+		backfillMu.Unlock()
+		return GetBackfillStatus(), ErrBackfillRunning
+	}
+	now := time.Now()
+	backfillStatus = BackfillStatus{Running: true, DryRun: dryRun, StartedAt: &now}
+	backfillMu.Unlock()
+
+	go func() {
+		err := runContentTypeBackfill(dryRun)
+
+		backfillMu.Lock()
+		defer backfillMu.Unlock()
+		finished := time.Now()
+		backfillStatus.Running = false
+		backfillStatus.FinishedAt = &finished
+		if err != nil {
+			backfillStatus.Errors = append(backfillStatus.Errors, err.Error())
+		}
+		log.Printf("Content type backfill finished: scanned=%d updated=%d failed=%d",
+			backfillStatus.Scanned, backfillStatus.Updated, backfillStatus.Failed)
+	}()
+	return GetBackfillStatus(), nil
+}
+
+func runContentTypeBackfill(dryRun bool) error {
+	ctx := context.Background()
+	objectCh := client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Recursive:    true,
+		WithMetadata: true,
+	})
+	for object := range objectCh {
+		if object.Err != nil {
+			return object.Err
+		}
+
+		updated, err := backfillObjectContentType(ctx, object, dryRun)
+
+		backfillMu.Lock()
+		backfillStatus.Scanned++
+		if err != nil {
+			backfillStatus.Failed++
+			if len(backfillStatus.Errors) < maxBackfillErrors {
+				backfillStatus.Errors = append(backfillStatus.Errors, object.Key+": "+err.Error())
+			}
+		} else if updated {
+			backfillStatus.Updated++
+		}
+		backfillMu.Unlock()
+	}
+	return nil
+}
+
+// 1オブジェクトのコンテンツタイプを判定し直して保存（更新した場合は true）
+func backfillObjectContentType(ctx context.Context, object minio.ObjectInfo, dryRun bool) (bool, error) {
+	current := object.ContentType
+	if current == "" {
+		current = object.Metadata.Get("Content-Type")
+	}
+	if (current != "" && current != "application/octet-stream") || path.Base(object.Key) == ".keep" || strings.HasPrefix(object.Key, blobPrefix) {
+		return false, nil
+	}
+
+	opts := minio.GetObjectOptions{}
+	if object.Size > sniffLength {
+		opts.SetRange(0, sniffLength-1)
+	}
+	obj, stat, err := getObject(ctx, object.Key, opts)
+	if err != nil {
+		return false, err
+	}
+	// 暗号化・圧縮されたファイルは先頭バイトから判定できない（コンテンツタイプはアップロード時に判定済み）
+	_, encrypted := envelopeFromMetadata(stat.UserMetadata)
+	_, compressed := compressedSize(stat.UserMetadata)
+	if encrypted || compressed {
+		obj.Close()
+		return false, nil
+	}
+	head, err := io.ReadAll(io.LimitReader(obj, sniffLength))
+	obj.Close()
+	if err != nil {
+		return false, err
+	}
+
+	contentType := DetectContentType(path.Base(object.Key), "", head)
+	if contentType == "application/octet-stream" {
+		return false, nil
+	}
+	if dryRun {
+		return true, nil
+	}
+
+	// 自身へのコピーでメタデータを置き換える（ユーザー定義メタデータは引き継ぐ、タグはそのまま）
+	stat, sse, err := statPhysical(ctx, object.Key, minio.StatObjectOptions{})
+	if err != nil {
+		return false, err
+	}
+	info, err := client.CopyObject(ctx,
+		minio.CopyDestOptions{
+			Bucket:          bucketName,
+			Object:          object.Key,
+			UserMetadata:    stat.UserMetadata,
+			ReplaceMetadata: true,
+			ContentType:     contentType,
+			Encryption:      writeSSE(object.Key),
+		},
+		minio.CopySrcOptions{
+			Bucket:     bucketName,
+			Object:     object.Key,
+			MatchETag:  stat.ETag, // 走査中に上書きされた場合は更新しない
+			Encryption: copySourceSSE(sse),
+		})
+	if err != nil {
+		return false, err
+	}
+
+	indexMu.RLock()
+	var tags map[string]string
+	if entry := metadataIndex[object.Key]; entry != nil {
+		tags = entry.Tags
+	}
+	indexMu.RUnlock()
+	indexObject(object.Key, logicalSize(stat), info.LastModified, contentType, tags, imageFromMetadata(stat.UserMetadata))
+	modTime = time.Now()
+	return true, nil
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// フォルダ複製の結果（一部失敗を含む）
+type CopyResult struct {
+	Source      string        `json:"source"`
+	Destination string        `json:"destination"`
+	Total       int           `json:"total"`
+	Copied      int           `json:"copied"`
+	TotalSize   int64         `json:"totalSize"`
+	Failed      int           `json:"failed"`
+	Errors      []ObjectError `json:"errors"`
+	Completed   bool          `json:"completed"`
+}
+
+// フォルダをサーバーサイドで再帰的に複製（.keepを含むプレフィックス配下の全オブジェクト）
+func CopyFolder(srcPrefix, dstPrefix string, overwrite bool) (*CopyResult, error) {
+	srcPrefix = strings.TrimSuffix(srcPrefix, "/") + "/"
+	dstPrefix = strings.TrimSuffix(dstPrefix, "/") + "/"
+
+	if srcPrefix == dstPrefix {
+		return nil, ErrAlreadyExists
+	}
+	if strings.HasPrefix(dstPrefix, srcPrefix) {
+		return nil, ErrCopyIntoSelf
+	}
+
+	objects, err := listAllObjects(srcPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, ErrNotFound
+	}
+
+	// 複製全体のサイズで事前にクォータを確認
+	var totalSize int64
+	for _, object := range objects {
+		totalSize += object.Size
+	}
+	reservation, err := reserveQuota(dstPrefix, totalSize, int64(len(objects)))
+	if err != nil {
+		return nil, err
+	}
+	defer reservation.release()
+
+	result := &CopyResult{
+		Source:      srcPrefix,
+		Destination: dstPrefix,
+		Total:       len(objects),
+		Errors:      []ObjectError{},
+	}
+
+	for _, object := range objects {
+		dstKey := dstPrefix + strings.TrimPrefix(object.Key, srcPrefix)
+
+		if err := copyFolderObject(object, dstKey, overwrite); err != nil {
+			result.Failed++
+			result.Errors = append(result.Errors, ObjectError{Key: object.Key, Error: err.Error()})
+			continue
+		}
+		reservation.consume(object.Size, 1)
+		result.Copied++
+		result.TotalSize += object.Size
+	}
+
+	result.Completed = result.Failed == 0
+	modTime = time.Now()
+	return result, nil
+}
+
+// フォルダ複製の1オブジェクト分を処理
+func copyFolderObject(object minio.ObjectInfo, dstKey string, overwrite bool) error {
+	if !overwrite {
+		_, err := statObject(context.Background(), dstKey, minio.StatObjectOptions{})
+		if err == nil {
+			return ErrAlreadyExists
+		}
+		if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+			return err
+		}
+	}
+	return copyObject(object.Key, dstKey, object.Size)
+}
@@ -0,0 +1,526 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"maps"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var (
+	// 重複排除モード（DEDUP_ENABLED、既定false）。無効にしても既存のポインタは読み取れる
+	DedupEnabled = envBool("DEDUP_ENABLED", false)
+	// 重複排除の対象とする最小ファイルサイズ（DEDUP_MIN_SIZE、既定1MiB）
+	DedupMinSize = envInt64("DEDUP_MIN_SIZE", 1<<20)
+	// 参照されなくなったblobの削除間隔（DEDUP_GC_INTERVAL、既定24時間）
+	DedupGCInterval = envDuration("DEDUP_GC_INTERVAL", 24*time.Hour)
+	// 参照がなくなってから削除するまでの猶予（DEDUP_GC_GRACE_PERIOD、既定1時間）
+	DedupGCGracePeriod = envDuration("DEDUP_GC_GRACE_PERIOD", time.Hour)
+)
+
+const (
+	// 内容のSHA-256をキーとするblobの保存先
+	blobPrefix = ".blobs/"
+	// パスとblobの対応表の保存先
+	dedupManifestKey = ".dedup/manifest.json"
+	// ポインタオブジェクトに保存する参照先blobと論理サイズ（x-amz-meta-dedup-*）
+	dedupBlobKey = "Dedup-Sha256"
+	dedupSizeKey = "Dedup-Size"
+	// 対応表の保存間隔
+	dedupFlushInterval = time.Minute
+)
+
+// blobの情報（Refs は現在のバージョンで参照しているパスの数）
+type blobRecord struct {
+	Size              int64     `json:"size"`
+	Refs              int64     `json:"refs"`
+	UnreferencedSince time.Time `json:"unreferencedSince,omitzero"`
+}
+
+// パスとblobの対応表
+type dedupManifest struct {
+	Objects map[string]string      `json:"objects"` // パス → SHA-256
+	Blobs   map[string]*blobRecord `json:"blobs"`   // SHA-256 → blob
+}
+
+// 重複排除の統計
+type DedupStats struct {
+	Enabled      bool           `json:"enabled"`
+	Blobs        int            `json:"blobs"`
+	References   int            `json:"references"`
+	StoredBytes  int64          `json:"storedBytes"`  // blobの合計サイズ
+	LogicalBytes int64          `json:"logicalBytes"` // ポインタが表すファイルの合計サイズ
+	SavedBytes   int64          `json:"savedBytes"`
+	LastGC       *DedupGCResult `json:"lastGC,omitempty"`
+}
+
+// blob削除の結果
+type DedupGCResult struct {
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Scanned    int       `json:"scanned"` // 確認したオブジェクトのバージョン数
+	Removed    int       `json:"removed"`
+	FreedBytes int64     `json:"freedBytes"`
+	Errors     []string  `json:"errors,omitempty"`
+}
+
+var (
+	dedupMu    sync.Mutex
+	manifest   = dedupManifest{Objects: map[string]string{}, Blobs: map[string]*blobRecord{}}
+	dedupDirty bool
+	lastGC     *DedupGCResult
+	// 保存処理（読み取り）とblob削除（書き込み）の排他
+	dedupGCMu sync.RWMutex
+)
+
+func blobKey(hash string) string {
+	return blobPrefix + hash[:2] + "/" + hash
+}
+
+// ポインタオブジェクトの参照先blobと論理サイズを取得（ポインタでなければ ok=false）
+func pointerTarget(metadata map[string]string) (hash string, size int64, ok bool) {
+	hash = metadata[dedupBlobKey]
+	if len(hash) < 2 {
+		return "", 0, false
+	}
+	size, err := strconv.ParseInt(metadata[dedupSizeKey], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return hash, size, true
+}
+
+// パスの参照先blobを設定（hash が空の場合は参照を外す）
+func setBlobRefLocked(key, hash string, size int64) {
+	old := manifest.Objects[key]
+	if old == hash {
+		return
+	}
+	if record := manifest.Blobs[old]; old != "" && record != nil {
+		record.Refs--
+		if record.Refs <= 0 {
+			record.Refs = 0
+			record.UnreferencedSince = time.Now()
+		}
+	}
+	delete(manifest.Objects, key)
+
+	if hash != "" {
+		record := manifest.Blobs[hash]
+		if record == nil {
+			record = &blobRecord{Size: size}
+			manifest.Blobs[hash] = record
+		}
+		record.Refs++
+		record.UnreferencedSince = time.Time{}
+		manifest.Objects[key] = hash
+	}
+	dedupDirty = true
+}
+
+func setBlobRef(key, hash string, size int64) {
+	dedupMu.Lock()
+	setBlobRefLocked(key, hash, size)
+	dedupMu.Unlock()
+}
+
+func releaseBlobRef(key string) {
+	setBlobRef(key, "", 0)
+}
+
+// 複製先のパスに複製元と同じ参照先を設定
+func copyBlobRef(srcKey, dstKey string) {
+	dedupMu.Lock()
+	defer dedupMu.Unlock()
+	hash := manifest.Objects[srcKey]
+	var size int64
+	if record := manifest.Blobs[hash]; record != nil {
+		size = record.Size
+	}
+	setBlobRefLocked(dstKey, hash, size)
+}
+
+// パスの現在のバージョンを確認して参照先を更新（過去バージョンの削除後など）
+func refreshBlobRef(ctx context.Context, key string) {
+	info, _, err := statPhysical(ctx, key, minio.StatObjectOptions{})
+	if err != nil {
+		releaseBlobRef(key)
+		return
+	}
+	hash, size, _ := pointerTarget(info.UserMetadata)
+	setBlobRef(key, hash, size)
+}
+
+// パスがポインタか判定（実体は空のオブジェクト）
+func isPointerKey(key string) bool {
+	dedupMu.Lock()
+	defer dedupMu.Unlock()
+	return manifest.Objects[key] != ""
+}
+
+// 一覧結果のサイズをファイルのサイズに置き換える
+// 圧縮・暗号化の判定にはメタデータが必要なため、一覧は WithMetadata を指定して取得すること
+func logicalObject(object minio.ObjectInfo) minio.ObjectInfo {
+	if object.IsDeleteMarker {
+		return object
+	}
+	if object.Size != 0 {
+		object.Size = logicalSize(minio.ObjectInfo{Size: object.Size, UserMetadata: listedMetadata(object.UserMetadata)})
+		return object
+	}
+	dedupMu.Lock()
+	if record := manifest.Blobs[manifest.Objects[object.Key]]; record != nil {
+		object.Size = record.Size
+	}
+	dedupMu.Unlock()
+	return object
+}
+
+// StatObject（ポインタ・圧縮・暗号化の場合は元のファイルのサイズを返す）
+func statObject(ctx context.Context, key string, opts minio.StatObjectOptions) (minio.ObjectInfo, error) {
+	info, _, err := statPhysical(ctx, key, opts)
+	if err != nil {
+		return info, err
+	}
+	info.Size = logicalSize(info)
+	return info, nil
+}
+
+// ポインタ・圧縮・暗号化の場合は元のファイルのサイズ、それ以外は実体のサイズ
+func logicalSize(info minio.ObjectInfo) int64 {
+	if _, size, ok := pointerTarget(info.UserMetadata); ok {
+		return size
+	}
+	if size, ok := compressedSize(info.UserMetadata); ok {
+		return size
+	}
+	if env, ok := envelopeFromMetadata(info.UserMetadata); ok {
+		return env.Size
+	}
+	return info.Size
+}
+
+// GetObject（ポインタの場合は参照先blobの内容を返す）
+// 返すオブジェクト情報はパス側のもの（サイズはファイルのサイズ）
+func getObject(ctx context.Context, key string, opts minio.GetObjectOptions) (*minio.Object, minio.ObjectInfo, error) {
+	info, sse, err := statPhysical(ctx, key, minio.StatObjectOptions{VersionID: opts.VersionID})
+	if err != nil {
+		return nil, info, err
+	}
+	opts.ServerSideEncryption = sse
+	if hash, size, ok := pointerTarget(info.UserMetadata); ok {
+		key = blobKey(hash)
+		opts.VersionID = ""
+		if _, opts.ServerSideEncryption, err = statPhysical(ctx, key, minio.StatObjectOptions{}); err != nil {
+			return nil, info, err
+		}
+		info.Size = size
+	}
+	obj, err := client.GetObject(ctx, bucketName, key, opts)
+	return obj, info, err
+}
+
+// 重複排除モードで保存（実体は内容のハッシュをキーとするblobに一度だけ置き、パスには参照先を記録した空のポインタを置く）
+func putDeduplicated(ctx context.Context, filename string, data io.Reader, size int64, sums Checksums, opts UploadOptions) (minio.UploadInfo, error) {
+	dedupGCMu.RLock()
+	defer dedupGCMu.RUnlock()
+
+	hash := sums.SHA256
+	dedupMu.Lock()
+	known := manifest.Blobs[hash] != nil
+	dedupMu.Unlock()
+
+	if !known {
+		// 対応表を失っていてもblobが残っていれば再利用する
+		if _, _, err := statPhysical(ctx, blobKey(hash), minio.StatObjectOptions{}); err != nil {
+			_, err := client.PutObject(ctx, bucketName, blobKey(hash), data, size, minio.PutObjectOptions{
+				ContentType:          opts.ContentType,
+				ServerSideEncryption: writeSSE(blobKey(hash)),
+			})
+			if err != nil {
+				return minio.UploadInfo{}, err
+			}
+		}
+		dedupMu.Lock()
+		if manifest.Blobs[hash] == nil {
+			manifest.Blobs[hash] = &blobRecord{Size: size, UnreferencedSince: time.Now()}
+			dedupDirty = true
+		}
+		dedupMu.Unlock()
+	}
+
+	metadata := sums.withMetadata(opts.Metadata)
+	metadata[dedupBlobKey] = hash
+	metadata[dedupSizeKey] = strconv.FormatInt(size, 10)
+	info, err := client.PutObject(ctx, bucketName, filename, bytes.NewReader(nil), 0, minio.PutObjectOptions{
+		UserMetadata:         metadata,
+		UserTags:             opts.Tags,
+		ContentType:          opts.ContentType,
+		ServerSideEncryption: writeSSE(filename),
+	})
+	if err != nil {
+		return info, err
+	}
+	setBlobRef(filename, hash, size)
+	info.Size = size
+	return info, nil
+}
+
+// 重複排除の統計を取得
+func GetDedupStats() DedupStats {
+	dedupMu.Lock()
+	defer dedupMu.Unlock()
+
+	stats := DedupStats{
+		Enabled:    DedupEnabled,
+		Blobs:      len(manifest.Blobs),
+		References: len(manifest.Objects),
+		LastGC:     lastGC,
+	}
+	for _, record := range manifest.Blobs {
+		stats.StoredBytes += record.Size
+	}
+	for _, hash := range manifest.Objects {
+		if record := manifest.Blobs[hash]; record != nil {
+			stats.LogicalBytes += record.Size
+		}
+	}
+	stats.SavedBytes = max(stats.LogicalBytes-stats.StoredBytes, 0)
+	return stats
+}
+
+// 対応表をバケットに保存
+func saveDedupManifest() error {
+	dedupMu.Lock()
+	if !dedupDirty {
+		dedupMu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(manifest)
+	dedupDirty = false
+	dedupMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PutObject(context.Background(), bucketName, dedupManifestKey, bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: "application/json"})
+	if err != nil {
+		dedupMu.Lock()
+		dedupDirty = true
+		dedupMu.Unlock()
+	}
+	return err
+}
+
+// 保存済みの対応表を読み込む（存在しない場合は false）
+func loadDedupManifest() (bool, error) {
+	obj, err := client.GetObject(context.Background(), bucketName, dedupManifestKey, minio.GetObjectOptions{})
+	if err != nil {
+		return false, err
+	}
+	defer obj.Close()
+
+	var loaded dedupManifest
+	if err := json.NewDecoder(obj).Decode(&loaded); err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return false, nil
+		}
+		return false, err
+	}
+	if loaded.Objects == nil {
+		loaded.Objects = map[string]string{}
+	}
+	if loaded.Blobs == nil {
+		loaded.Blobs = map[string]*blobRecord{}
+	}
+
+	dedupMu.Lock()
+	manifest = loaded
+	dedupMu.Unlock()
+	return true, nil
+}
+
+// 全バージョンを走査して参照されているblobを集め、参照数を再集計
+// ポインタは空のオブジェクトのため、サイズ0のオブジェクトのみメタデータを確認する
+func markBlobs(ctx context.Context) (map[string]bool, int, error) {
+	marked := map[string]bool{}
+	current := map[string]string{}
+	sizes := map[string]int64{}
+	scanned := 0
+
+	dedupMu.Lock()
+	before := maps.Clone(manifest.Objects)
+	dedupMu.Unlock()
+
+	objectCh := client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Recursive:    true,
+		WithVersions: true,
+	})
+	for object := range objectCh {
+		if object.Err != nil {
+			return nil, scanned, object.Err
+		}
+		scanned++
+		if object.Size != 0 || object.IsDeleteMarker || path.Base(object.Key) == ".keep" {
+			continue
+		}
+
+		info, _, err := statPhysical(ctx, object.Key, minio.StatObjectOptions{VersionID: object.VersionID})
+		if err != nil {
+			if isNoSuchObject(err) {
+				continue
+			}
+			return nil, scanned, err
+		}
+		hash, size, ok := pointerTarget(info.UserMetadata)
+		if !ok {
+			continue
+		}
+		marked[hash] = true
+		sizes[hash] = size
+		if object.IsLatest || object.VersionID == "" {
+			current[object.Key] = hash
+		}
+	}
+
+	// 走査中に変更されたパスはそのままにし、それ以外を走査結果に合わせる
+	dedupMu.Lock()
+	for key, hash := range current {
+		if manifest.Objects[key] == before[key] {
+			setBlobRefLocked(key, hash, sizes[hash])
+		}
+	}
+	for key, hash := range before {
+		if _, ok := current[key]; !ok && manifest.Objects[key] == hash {
+			setBlobRefLocked(key, "", 0)
+		}
+	}
+	for hash := range marked {
+		if manifest.Blobs[hash] == nil {
+			manifest.Blobs[hash] = &blobRecord{Size: sizes[hash], UnreferencedSince: time.Now()}
+			dedupDirty = true
+		}
+	}
+	dedupMu.Unlock()
+	return marked, scanned, nil
+}
+
+// どのバージョンからも参照されず、猶予期間を過ぎたblobを削除
+func CollectGarbageBlobs() (*DedupGCResult, error) {
+	ctx := context.Background()
+	result := &DedupGCResult{StartedAt: time.Now()}
+
+	marked, scanned, err := markBlobs(ctx)
+	result.Scanned = scanned
+	if err != nil {
+		return nil, err
+	}
+
+	// 削除中に新しいポインタが同じblobを参照しないよう保存処理を止める
+	dedupGCMu.Lock()
+	cutoff := time.Now().Add(-DedupGCGracePeriod)
+	var candidates []string
+	dedupMu.Lock()
+	for hash, record := range manifest.Blobs {
+		if record.Refs == 0 && !marked[hash] && record.UnreferencedSince.Before(cutoff) {
+			candidates = append(candidates, hash)
+		}
+	}
+	dedupMu.Unlock()
+
+	for _, hash := range candidates {
+		if err := removeAllVersions(ctx, blobKey(hash)); err != nil {
+			result.Errors = append(result.Errors, hash+": "+err.Error())
+			continue
+		}
+		dedupMu.Lock()
+		result.FreedBytes += manifest.Blobs[hash].Size
+		delete(manifest.Blobs, hash)
+		dedupDirty = true
+		dedupMu.Unlock()
+		result.Removed++
+	}
+	dedupGCMu.Unlock()
+
+	result.FinishedAt = time.Now()
+	dedupMu.Lock()
+	lastGC = result
+	dedupMu.Unlock()
+
+	if err := saveDedupManifest(); err != nil {
+		log.Printf("Dedup manifest save error: %v", err)
+	}
+	return result, nil
+}
+
+// キーの全バージョンを完全に削除（バージョニング無効時は通常の削除）
+// 削除した過去のバージョンは使用量から差し引く（現在のバージョンの分は呼び出し側で反映する）
+func removeAllVersions(ctx context.Context, key string) error {
+	versions, err := listKeyVersions(ctx, key)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		if err := client.RemoveObject(ctx, bucketName, key, minio.RemoveObjectOptions{VersionID: version.VersionID}); err != nil {
+			return err
+		}
+		if !version.IsLatest && !version.IsDeleteMarker {
+			trackVersions(key, -versionSize(version))
+		}
+	}
+	return nil
+}
+
+// 対応表を読み込み、定期的な保存とblobの削除を開始
+// 対応表がなければ走査して作り直してから返す（一覧のサイズ表示に必要なため）
+func StartDedupCollector() error {
+	loaded, err := loadDedupManifest()
+	if err != nil {
+		log.Printf("Dedup manifest ignored: %v", err)
+	}
+	if !loaded {
+		if _, _, err := markBlobs(context.Background()); err != nil {
+			return err
+		}
+		// ポインタがなくても保存し、次回の起動時に走査しない
+		dedupMu.Lock()
+		dedupDirty = true
+		dedupMu.Unlock()
+		if err := saveDedupManifest(); err != nil {
+			log.Printf("Dedup manifest save error: %v", err)
+		}
+	}
+
+	go func() {
+		flush := time.NewTicker(dedupFlushInterval)
+		defer flush.Stop()
+		gc := time.NewTicker(DedupGCInterval)
+		defer gc.Stop()
+
+		for {
+			select {
+			case <-flush.C:
+				if err := saveDedupManifest(); err != nil {
+					log.Printf("Dedup manifest save error: %v", err)
+				}
+			case <-gc.C:
+				result, err := CollectGarbageBlobs()
+				if err != nil {
+					log.Printf("Dedup GC error: %v", err)
+				} else if result.Removed > 0 {
+					log.Printf("Dedup GC removed %d blobs (%s)", result.Removed, formatSizeBytes(result.FreedBytes))
+				}
+			}
+		}
+	}()
+	return nil
+}
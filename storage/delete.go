@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// RemoveObjectsに一度に渡すオブジェクト数
+const deleteBatchSize = 1000
+
+// フォルダ削除の結果（dryRun時は削除対象の一覧のみ）
+type DeleteResult struct {
+	Prefix    string        `json:"prefix"`
+	DryRun    bool          `json:"dryRun"`
+	Total     int           `json:"total"`
+	TotalSize int64         `json:"totalSize"`
+	Deleted   int           `json:"deleted"`
+	Failed    int           `json:"failed"`
+	Objects   []string      `json:"objects,omitempty"`
+	Errors    []ObjectError `json:"errors"`
+}
+
+// フォルダを再帰的に削除（.keepを含むプレフィックス配下の全オブジェクト）
+func DeleteFolder(prefix string, dryRun bool) (*DeleteResult, error) {
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+
+	objects, err := listAllObjects(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, ErrNotFound
+	}
+
+	result := &DeleteResult{
+		Prefix: prefix,
+		DryRun: dryRun,
+		Total:  len(objects),
+		Errors: []ObjectError{},
+	}
+	for _, object := range objects {
+		result.TotalSize += object.Size
+	}
+
+	// dryRunの場合は削除対象を返すのみ
+	if dryRun {
+		result.Objects = make([]string, 0, len(objects))
+		for _, object := range objects {
+			result.Objects = append(result.Objects, object.Key)
+		}
+		return result, nil
+	}
+
+	for start := 0; start < len(objects); start += deleteBatchSize {
+		end := start + deleteBatchSize
+		if end > len(objects) {
+			end = len(objects)
+		}
+		errs := removeObjectBatch(objects[start:end])
+		result.Errors = append(result.Errors, errs...)
+		result.Deleted += (end - start) - len(errs)
+	}
+
+	result.Failed = len(result.Errors)
+	modTime = time.Now()
+	return result, nil
+}
+
+// RemoveObjectsで一括削除し、オブジェクト単位のエラーを返す
+// バージョニング有効時は過去のバージョンと削除マーカーも削除し、容量を解放する
+func removeObjectBatch(objects []minio.ObjectInfo) []ObjectError {
+	ctx := context.Background()
+
+	var errs []ObjectError
+	failed := map[string]bool{}
+	targets := objects
+	noncurrent := map[string]int64{}
+	if VersioningEnabled {
+		targets = nil
+		for _, object := range objects {
+			versions, err := listKeyVersions(ctx, object.Key)
+			if err != nil {
+				errs = append(errs, ObjectError{Key: object.Key, Error: err.Error()})
+				failed[object.Key] = true
+				continue
+			}
+			targets = append(targets, versions...)
+			noncurrent[object.Key] = noncurrentSize(versions)
+		}
+	}
+
+	objectsCh := make(chan minio.ObjectInfo, len(targets))
+	for _, object := range targets {
+		objectsCh <- object
+	}
+	close(objectsCh)
+
+	for removeErr := range client.RemoveObjects(ctx, bucketName, objectsCh, minio.RemoveObjectsOptions{}) {
+		if failed[removeErr.ObjectName] {
+			continue // 同じキーの別バージョンのエラーは1件にまとめる
+		}
+		errs = append(errs, ObjectError{Key: removeErr.ObjectName, Error: removeErr.Err.Error()})
+		failed[removeErr.ObjectName] = true
+	}
+
+	// 削除できたオブジェクトを使用量に反映
+	for _, object := range objects {
+		if !failed[object.Key] {
+			releaseBlobRef(object.Key)
+			recordChange(object.Key, -object.Size, -1)
+			trackVersions(object.Key, -noncurrent[object.Key])
+			unindexObject(object.Key)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"context"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+var ErrInvalidEncryptionConfig = errors.New("invalid server-side encryption configuration")
+
+var (
+	// 新しく保存するオブジェクトの暗号化方式（SSE_MODE: "none" / "s3" / "c"、既定none）
+	SSEMode = strings.ToLower(envString("SSE_MODE", "none"))
+	// SSE-Cの鍵を導出するマスターキー（SSE_MASTER_KEY、32バイトをBase64または16進数で指定）
+	SSEMasterKey = os.Getenv("SSE_MASTER_KEY")
+	// MinIOへHTTPSで接続するか（MINIO_SECURE、既定false。SSE-CはHTTPS接続が必須）
+	MinIOSecure = envBool("MINIO_SECURE", false)
+)
+
+var (
+	masterKey []byte
+	dataKeyMu sync.Mutex
+	dataKeys  = map[string]encrypt.ServerSide{}
+)
+
+// 暗号化設定を検証し、マスターキーを読み込む
+func initEncryption() error {
+	switch SSEMode {
+	case "none", "s3", "c":
+	default:
+		return fmt.Errorf("%w: SSE_MODE must be none, s3 or c", ErrInvalidEncryptionConfig)
+	}
+
+	if SSEMasterKey != "" {
+		key, ok := decodeKey(SSEMasterKey)
+		if !ok {
+			return fmt.Errorf("%w: SSE_MASTER_KEY must be 32 bytes in hex or base64", ErrInvalidEncryptionConfig)
+		}
+		masterKey = key
+	}
+	if SSEMode == "c" && masterKey == nil {
+		return fmt.Errorf("%w: SSE_MODE=c requires SSE_MASTER_KEY", ErrInvalidEncryptionConfig)
+	}
+	// MinIOはHTTPS以外でSSE-Cの鍵を受け付けないため、起動しても全ての保存が失敗する
+	if SSEMode == "c" && !MinIOSecure {
+		return fmt.Errorf("%w: SSE_MODE=c requires MINIO_SECURE=true", ErrInvalidEncryptionConfig)
+	}
+	// 重複排除のblobは所有者をまたいで共有されるため、所有者ごとの鍵で暗号化できない
+	if SSEMode == "c" && DedupEnabled {
+		return fmt.Errorf("%w: SSE_MODE=c cannot be combined with DEDUP_ENABLED (deduplicated blobs are shared between owners)", ErrInvalidEncryptionConfig)
+	}
+	// 過去にSSE-Cで保存したオブジェクトの読み取りにもHTTPSが必要
+	if masterKey != nil && !MinIOSecure {
+		log.Println("Warning: SSE-C requires MINIO_SECURE=true; MinIO rejects customer keys over plain HTTP")
+	}
+	return nil
+}
+
+// 16進数またはBase64の32バイトの鍵を読み込む
+func decodeKey(value string) ([]byte, bool) {
+	key, err := hex.DecodeString(value)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(value)
+	}
+	return key, err == nil && len(key) == 32
+}
+
+// 所有者ごとのSSE-C鍵をマスターキーから導出（マスターキー未設定の場合は nil）
+// 所有者はクォータと同じ単位（"user:<ID>" / "group:<名前>"）、内部領域は共通の鍵を使う
+func ownerSSEKey(owner string) encrypt.ServerSide {
+	if masterKey == nil {
+		return nil
+	}
+	if owner == "" {
+		owner = "system"
+	}
+
+	dataKeyMu.Lock()
+	defer dataKeyMu.Unlock()
+	if sse, ok := dataKeys[owner]; ok {
+		return sse
+	}
+	key, err := hkdf.Key(sha256.New, masterKey, nil, "go-minio sse-c "+owner, 32)
+	if err != nil {
+		return nil
+	}
+	sse, err := encrypt.NewSSEC(key)
+	if err != nil {
+		return nil
+	}
+	dataKeys[owner] = sse
+	return sse
+}
+
+// キーの所有者のSSE-C鍵
+func objectSSEKey(key string) encrypt.ServerSide {
+	return ownerSSEKey(quotaOwner(key))
+}
+
+// 新しく保存するオブジェクトの暗号化設定
+func writeSSE(key string) encrypt.ServerSide {
+	switch SSEMode {
+	case "s3":
+		return encrypt.NewSSE()
+	case "c":
+		return objectSSEKey(key)
+	}
+	return nil
+}
+
+// 読み取り時に試す暗号化設定（保存時の方式は不明なため、現在の方式を先に試す）
+func readSSEAttempts(key string) []encrypt.ServerSide {
+	ssec := objectSSEKey(key)
+	switch {
+	case ssec == nil:
+		return []encrypt.ServerSide{nil}
+	case SSEMode == "c":
+		return []encrypt.ServerSide{ssec, nil}
+	default:
+		return []encrypt.ServerSide{nil, ssec}
+	}
+}
+
+// 複製元に指定する暗号化設定（SSE-Cの場合のみ鍵が必要）
+func copySourceSSE(sse encrypt.ServerSide) encrypt.ServerSide {
+	if sse == nil || sse.Type() != encrypt.SSEC {
+		return nil
+	}
+	return sse
+}
+
+func isNoSuchObject(err error) bool {
+	code := minio.ToErrorResponse(err).Code
+	return code == "NoSuchKey" || code == "NoSuchVersion"
+}
+
+// 暗号化設定を切り替えながらStatObjectし、成功した設定も返す（サイズは保存されている実体のもの）
+func statPhysical(ctx context.Context, key string, opts minio.StatObjectOptions) (minio.ObjectInfo, encrypt.ServerSide, error) {
+	var info minio.ObjectInfo
+	var err error
+	for _, sse := range readSSEAttempts(key) {
+		opts.ServerSideEncryption = sse
+		info, err = client.StatObject(ctx, bucketName, key, opts)
+		if err == nil {
+			return info, sse, nil
+		}
+		if isNoSuchObject(err) {
+			break
+		}
+	}
+	return info, nil, err
+}
+
+// 複製元の暗号化設定を確認（マスターキー未設定の場合は確認しない）
+func sourceSSE(ctx context.Context, key, versionID string) (encrypt.ServerSide, error) {
+	if masterKey == nil {
+		return nil, nil
+	}
+	_, sse, err := statPhysical(ctx, key, minio.StatObjectOptions{VersionID: versionID})
+	return copySourceSSE(sse), err
+}
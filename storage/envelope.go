@@ -0,0 +1,606 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var (
+	ErrEnvelopeKeyUnavailable = errors.New("envelope key is not available")
+	ErrEnvelopeCorrupted      = errors.New("encrypted object is corrupted")
+	ErrRewrapRunning          = errors.New("envelope key rotation is already running")
+)
+
+var (
+	// 保存前に本サーバーで暗号化するフォルダ（ENVELOPE_FOLDERS、各スペースのルートからのパスをカンマ区切り）
+	EnvelopeFolders = splitFolderList(os.Getenv("ENVELOPE_FOLDERS"))
+	// データ鍵を暗号化する鍵（ENVELOPE_KEYS、"ID:鍵" のカンマ区切り、鍵は32バイトをBase64または16進数で指定）
+	EnvelopeKeys = os.Getenv("ENVELOPE_KEYS")
+	// 新しくデータ鍵を暗号化する鍵のID（ENVELOPE_ACTIVE_KEY、未設定の場合は ENVELOPE_KEYS の先頭）
+	EnvelopeActiveKey = os.Getenv("ENVELOPE_ACTIVE_KEY")
+)
+
+// 暗号化の情報を保存するメタデータのキー（x-amz-meta-envelope-*）
+const (
+	envelopeKeyIDKey   = "Envelope-Kek"
+	envelopeDataKeyKey = "Envelope-Key"
+	envelopeSizeKey    = "Envelope-Size"
+)
+
+// 暗号化の単位（平文のバイト数）。範囲読み取りはこの単位で復号する
+const (
+	envelopeChunkSize = 64 << 10
+	envelopeTagSize   = 16
+)
+
+// IDごとの鍵暗号化鍵
+var keyEncryptionKeys = map[string]cipher.AEAD{}
+
+// カンマ区切りのフォルダ一覧を前後のスラッシュを除いて分割（大文字・小文字は区別する）
+func splitFolderList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.Trim(strings.TrimSpace(item), "/"); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// 鍵暗号化鍵の設定を検証して読み込む
+func initEnvelope() error {
+	keys := map[string]cipher.AEAD{}
+	first := ""
+	for _, item := range strings.Split(EnvelopeKeys, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		id, value, ok := strings.Cut(item, ":")
+		key, valid := decodeKey(value)
+		if !ok || id == "" || !valid {
+			return fmt.Errorf("%w: ENVELOPE_KEYS must be a list of id:key with 32-byte keys", ErrInvalidEncryptionConfig)
+		}
+		if keys[id] != nil {
+			return fmt.Errorf("%w: duplicate key id %q in ENVELOPE_KEYS", ErrInvalidEncryptionConfig, id)
+		}
+		aead, err := newGCM(key)
+		if err != nil {
+			return err
+		}
+		keys[id] = aead
+		if first == "" {
+			first = id
+		}
+	}
+
+	if EnvelopeActiveKey == "" {
+		EnvelopeActiveKey = first
+	}
+	if EnvelopeActiveKey != "" && keys[EnvelopeActiveKey] == nil {
+		return fmt.Errorf("%w: ENVELOPE_ACTIVE_KEY %q is not in ENVELOPE_KEYS", ErrInvalidEncryptionConfig, EnvelopeActiveKey)
+	}
+	if len(EnvelopeFolders) > 0 && EnvelopeActiveKey == "" {
+		return fmt.Errorf("%w: ENVELOPE_FOLDERS requires ENVELOPE_KEYS", ErrInvalidEncryptionConfig)
+	}
+	keyEncryptionKeys = keys
+	return nil
+}
+
+// 保存時に暗号化するフォルダ内のキーか
+func isEnvelopePath(key string) bool {
+	if len(EnvelopeFolders) == 0 || !isIndexable(key) {
+		return false
+	}
+	rel := strings.TrimPrefix(key, fullTextRoot(key)+"/")
+	for _, folder := range EnvelopeFolders {
+		if strings.HasPrefix(rel, folder+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// データ鍵を現在の鍵暗号化鍵で暗号化（鍵のIDをAADとして結び付ける）
+func wrapDataKey(dataKey []byte) (keyID, wrapped string, err error) {
+	kek := keyEncryptionKeys[EnvelopeActiveKey]
+	if kek == nil {
+		return "", "", fmt.Errorf("%w: %s", ErrEnvelopeKeyUnavailable, EnvelopeActiveKey)
+	}
+	nonce := make([]byte, kek.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", "", err
+	}
+	sealed := kek.Seal(nonce, nonce, dataKey, []byte(EnvelopeActiveKey))
+	return EnvelopeActiveKey, base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// 暗号化されたデータ鍵を復号
+func unwrapDataKey(keyID, wrapped string) ([]byte, error) {
+	kek := keyEncryptionKeys[keyID]
+	if kek == nil {
+		return nil, fmt.Errorf("%w: %s", ErrEnvelopeKeyUnavailable, keyID)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil || len(sealed) < kek.NonceSize() {
+		return nil, fmt.Errorf("%w: invalid wrapped key", ErrEnvelopeCorrupted)
+	}
+	dataKey, err := kek.Open(nil, sealed[:kek.NonceSize()], sealed[kek.NonceSize():], []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("%w: wrapped key does not match %s", ErrEnvelopeCorrupted, keyID)
+	}
+	return dataKey, nil
+}
+
+// メタデータに保存された暗号化の情報
+type envelopeInfo struct {
+	KeyID      string
+	WrappedKey string
+	Size       int64 // 平文のサイズ
+}
+
+func envelopeFromMetadata(metadata map[string]string) (envelopeInfo, bool) {
+	env := envelopeInfo{KeyID: metadata[envelopeKeyIDKey], WrappedKey: metadata[envelopeDataKeyKey]}
+	if env.KeyID == "" || env.WrappedKey == "" {
+		return envelopeInfo{}, false
+	}
+	size, err := strconv.ParseInt(metadata[envelopeSizeKey], 10, 64)
+	if err != nil || size < 0 {
+		return envelopeInfo{}, false
+	}
+	env.Size = size
+	return env, true
+}
+
+// 平文のサイズから暗号文のサイズを計算（空のファイルも認証タグのみのチャンクを1つ持つ）
+func envelopeCipherSize(size int64) int64 {
+	chunks := max(1, (size+envelopeChunkSize-1)/envelopeChunkSize)
+	return size + chunks*envelopeTagSize
+}
+
+// チャンクのノンス（先頭8バイトが通し番号、末尾1バイトが最終チャンクの印）
+// データ鍵はファイルごとに異なるため、通し番号でもノンスは重複しない
+func chunkNonce(index uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, index)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// 平文を読み取りながらチャンクごとに暗号化するReader
+type envelopeSealer struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	keyID   string
+	wrapped string
+	index   uint64
+	plain   []byte
+	sealed  []byte
+	out     []byte
+	size    int64 // 暗号化した平文のバイト数
+	done    bool
+}
+
+// ファイルごとのデータ鍵を生成して暗号化を開始
+func newEnvelopeSealer(src io.Reader) (*envelopeSealer, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	keyID, wrapped, err := wrapDataKey(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &envelopeSealer{
+		src:     bufio.NewReaderSize(src, envelopeChunkSize),
+		aead:    aead,
+		keyID:   keyID,
+		wrapped: wrapped,
+		plain:   make([]byte, envelopeChunkSize),
+		sealed:  make([]byte, 0, envelopeChunkSize+envelopeTagSize),
+	}, nil
+}
+
+func (s *envelopeSealer) Read(p []byte) (int, error) {
+	for len(s.out) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(s.src, s.plain)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		// 続きがない場合は最終チャンクとして暗号化し、末尾の切り詰めを検出できるようにする
+		final := n < len(s.plain)
+		if !final {
+			if _, err := s.src.Peek(1); err == io.EOF {
+				final = true
+			} else if err != nil {
+				return 0, err
+			}
+		}
+		s.out = s.aead.Seal(s.sealed[:0], chunkNonce(s.index, final), s.plain[:n], nil)
+		s.index++
+		s.size += int64(n)
+		s.done = final
+	}
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+// 暗号化の情報を付加したユーザー定義メタデータ
+func (s *envelopeSealer) withMetadata(metadata map[string]string, size int64) map[string]string {
+	merged := maps.Clone(metadata)
+	if merged == nil {
+		merged = map[string]string{}
+	}
+	merged[envelopeKeyIDKey] = s.keyID
+	merged[envelopeDataKeyKey] = s.wrapped
+	merged[envelopeSizeKey] = strconv.FormatInt(size, 10)
+	return merged
+}
+
+// 暗号化して保存（暗号文はファイルごとに異なるため重複排除の対象外）
+func putEnvelope(ctx context.Context, filename string, data io.Reader, size int64, sums Checksums, opts UploadOptions) (minio.UploadInfo, error) {
+	sealer, err := newEnvelopeSealer(data)
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	info, err := client.PutObject(ctx, bucketName, filename, sealer, envelopeCipherSize(size), minio.PutObjectOptions{
+		UserMetadata:         sealer.withMetadata(sums.withMetadata(opts.Metadata), size),
+		UserTags:             opts.Tags,
+		ContentType:          opts.ContentType,
+		ServerSideEncryption: writeSSE(filename),
+	})
+	if err != nil {
+		return info, err
+	}
+	info.Size = size
+	return info, nil
+}
+
+// 暗号化されていないファイルを暗号化フォルダへ複製
+// サーバーサイドコピーでは平文のまま残るため、複製元を読み出して暗号化し直す
+func copyIntoEnvelope(srcKey, dstKey string, size int64) error {
+	ctx := context.Background()
+
+	obj, stat, err := getObject(ctx, srcKey, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	reader, plain, err := decompressObject(obj, stat)
+	if err != nil {
+		obj.Close()
+		return err
+	}
+	defer reader.Close()
+	tags, err := GetTags(srcKey)
+	if err != nil {
+		return err
+	}
+
+	// 重複排除・圧縮の情報は複製先では使わない（画像のメタデータ等は引き継ぐ）
+	metadata := maps.Clone(stat.UserMetadata)
+	for _, key := range []string{checksumMD5Key, checksumSHA256Key, dedupBlobKey, dedupSizeKey, compressionKey, compressionSizeKey, compressionFrameKey} {
+		delete(metadata, key)
+	}
+	var sums Checksums
+	if stored := checksumsFromMetadata(stat.UserMetadata); stored != nil {
+		sums = *stored
+	}
+	opts := UploadOptions{Metadata: metadata, Tags: tags, ContentType: stat.ContentType}
+	if _, err := putEnvelope(ctx, dstKey, reader, plain.Size, sums, opts); err != nil {
+		return err
+	}
+
+	releaseBlobRef(dstKey)
+	recordChange(dstKey, size, 1)
+	indexCopy(srcKey, dstKey, size)
+	return nil
+}
+
+// 暗号化されたオブジェクトを復号しながら読み取る（チャンク単位で任意の位置から読める）
+type envelopeReader struct {
+	obj interface {
+		io.ReaderAt
+		io.Closer
+	}
+	aead       cipher.AEAD
+	size       int64
+	cipherSize int64
+	pos        int64
+	index      int64 // 復号済みのチャンク（-1: なし）
+	chunk      []byte
+	buf        []byte
+}
+
+func (r *envelopeReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	index := r.pos / envelopeChunkSize
+	if index != r.index {
+		if err := r.decryptChunk(index); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.chunk[r.pos-index*envelopeChunkSize:])
+	r.pos += int64(n)
+	return n, nil
+}
+
+// 指定したチャンクのみを範囲読み取りして復号
+func (r *envelopeReader) decryptChunk(index int64) error {
+	offset := index * (envelopeChunkSize + envelopeTagSize)
+	length := min(envelopeChunkSize+envelopeTagSize, r.cipherSize-offset)
+	n, err := r.obj.ReadAt(r.buf[:length], offset)
+	if int64(n) < length {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	final := offset+length == r.cipherSize
+	chunk, err := r.aead.Open(r.chunk[:0], chunkNonce(uint64(index), final), r.buf[:length], nil)
+	if err != nil {
+		r.index = -1
+		return fmt.Errorf("%w: chunk %d", ErrEnvelopeCorrupted, index)
+	}
+	r.chunk, r.index = chunk, index
+	return nil
+}
+
+func (r *envelopeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *envelopeReader) Close() error {
+	return r.obj.Close()
+}
+
+// 暗号化されている場合は復号するReaderに置き換え、サイズを平文のサイズにする
+// （obj は範囲指定なしで開いたものであること）
+func decryptObject(obj *minio.Object, info minio.ObjectInfo) (io.ReadSeekCloser, minio.ObjectInfo, error) {
+	env, ok := envelopeFromMetadata(info.UserMetadata)
+	if !ok {
+		return obj, info, nil
+	}
+	if envelopeCipherSize(env.Size) != info.Size {
+		return nil, info, fmt.Errorf("%w: size does not match", ErrEnvelopeCorrupted)
+	}
+	dataKey, err := unwrapDataKey(env.KeyID, env.WrappedKey)
+	if err != nil {
+		return nil, info, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, info, err
+	}
+
+	reader := &envelopeReader{
+		obj:        obj,
+		aead:       aead,
+		size:       env.Size,
+		cipherSize: info.Size,
+		index:      -1,
+		chunk:      make([]byte, 0, envelopeChunkSize),
+		buf:        make([]byte, envelopeChunkSize+envelopeTagSize),
+	}
+	info.Size = env.Size
+	return reader, info, nil
+}
+
+// 鍵の入れ替えジョブの進捗
+type RewrapStatus struct {
+	Running       bool       `json:"running"`
+	DryRun        bool       `json:"dryRun"`
+	ActiveKey     string     `json:"activeKey"`
+	StartedAt     *time.Time `json:"startedAt,omitempty"`
+	FinishedAt    *time.Time `json:"finishedAt,omitempty"`
+	Scanned       int64      `json:"scanned"`
+	Rewrapped     int64      `json:"rewrapped"`
+	Failed        int64      `json:"failed"`
+	StaleVersions int64      `json:"staleVersions"` // 古い鍵のまま残っている過去のバージョンの数
+	Errors        []string   `json:"errors,omitempty"`
+}
+
+var (
+	rewrapMu     sync.Mutex
+	rewrapStatus RewrapStatus
+)
+
+// 鍵の入れ替えジョブの進捗を取得
+func GetRewrapStatus() RewrapStatus {
+	rewrapMu.Lock()
+	defer rewrapMu.Unlock()
+	status := rewrapStatus
+	status.Errors = append([]string(nil), rewrapStatus.Errors...)
+	return status
+}
+
+// 現在の鍵以外で暗号化されたデータ鍵を現在の鍵で暗号化し直すジョブを開始
+// データ本体は再暗号化しない。dryRun の場合は対象の件数のみ数える
+func StartEnvelopeRewrap(dryRun bool) (RewrapStatus, error) {
+	rewrapMu.Lock()
+	if rewrapStatus.Running {
+		rewrapMu.Unlock()
+		return GetRewrapStatus(), ErrRewrapRunning
+	}
+	if EnvelopeActiveKey == "" {
+		rewrapMu.Unlock()
+		return GetRewrapStatus(), fmt.Errorf("%w: ENVELOPE_KEYS is not set", ErrEnvelopeKeyUnavailable)
+	}
+	now := time.Now()
+	rewrapStatus = RewrapStatus{Running: true, DryRun: dryRun, ActiveKey: EnvelopeActiveKey, StartedAt: &now}
+	rewrapMu.Unlock()
+
+	go func() {
+		err := runEnvelopeRewrap(dryRun)
+
+		rewrapMu.Lock()
+		defer rewrapMu.Unlock()
+		finished := time.Now()
+		rewrapStatus.Running = false
+		rewrapStatus.FinishedAt = &finished
+		if err != nil {
+			rewrapStatus.Errors = append(rewrapStatus.Errors, err.Error())
+		}
+		log.Printf("Envelope key rotation finished: scanned=%d rewrapped=%d failed=%d staleVersions=%d",
+			rewrapStatus.Scanned, rewrapStatus.Rewrapped, rewrapStatus.Failed, rewrapStatus.StaleVersions)
+	}()
+	return GetRewrapStatus(), nil
+}
+
+func runEnvelopeRewrap(dryRun bool) error {
+	ctx := context.Background()
+	objectCh := client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Recursive:    true,
+		WithVersions: VersioningEnabled,
+		WithMetadata: true, // 過去のバージョンの鍵のIDを確認するため
+	})
+	for object := range objectCh {
+		if object.Err != nil {
+			return object.Err
+		}
+		// 暗号文は認証タグを含むため、それより小さいオブジェクトやblobは対象外
+		if object.IsDeleteMarker || object.Size < envelopeTagSize || path.Base(object.Key) == ".keep" || strings.HasPrefix(object.Key, blobPrefix) {
+			continue
+		}
+		// 過去のバージョンはメタデータを更新できないため、古い鍵のままの数のみ数える
+		if VersioningEnabled && !object.IsLatest {
+			if env, ok := envelopeFromMetadata(listedMetadata(object.UserMetadata)); ok && env.KeyID != EnvelopeActiveKey {
+				rewrapMu.Lock()
+				rewrapStatus.StaleVersions++
+				rewrapMu.Unlock()
+			}
+			continue
+		}
+
+		rewrapped, err := rewrapObject(ctx, object.Key, dryRun)
+
+		rewrapMu.Lock()
+		rewrapStatus.Scanned++
+		if err != nil {
+			rewrapStatus.Failed++
+			if len(rewrapStatus.Errors) < maxBackfillErrors {
+				rewrapStatus.Errors = append(rewrapStatus.Errors, object.Key+": "+err.Error())
+			}
+		} else if rewrapped {
+			rewrapStatus.Rewrapped++
+		}
+		rewrapMu.Unlock()
+	}
+	return nil
+}
+
+// 1オブジェクトのデータ鍵を現在の鍵で暗号化し直す（更新した場合は true）
+func rewrapObject(ctx context.Context, key string, dryRun bool) (bool, error) {
+	stat, sse, err := statPhysical(ctx, key, minio.StatObjectOptions{})
+	if err != nil {
+		return false, err
+	}
+	env, ok := envelopeFromMetadata(stat.UserMetadata)
+	if !ok || env.KeyID == EnvelopeActiveKey {
+		return false, nil
+	}
+	dataKey, err := unwrapDataKey(env.KeyID, env.WrappedKey)
+	if err != nil {
+		return false, err
+	}
+	if dryRun {
+		return true, nil
+	}
+	keyID, wrapped, err := wrapDataKey(dataKey)
+	if err != nil {
+		return false, err
+	}
+
+	// 自身へのコピーでメタデータのみ置き換える（タグはそのまま）
+	metadata := maps.Clone(stat.UserMetadata)
+	metadata[envelopeKeyIDKey] = keyID
+	metadata[envelopeDataKeyKey] = wrapped
+	dst := minio.CopyDestOptions{
+		Bucket:          bucketName,
+		Object:          key,
+		UserMetadata:    metadata,
+		ReplaceMetadata: true,
+		ContentType:     stat.ContentType,
+		Encryption:      writeSSE(key),
+	}
+	src := minio.CopySrcOptions{
+		Bucket:     bucketName,
+		Object:     key,
+		MatchETag:  stat.ETag, // 走査中に上書きされた場合は更新しない
+		Encryption: copySourceSSE(sse),
+	}
+	var info minio.UploadInfo
+	if stat.Size > maxCopyObjectSize {
+		info, err = client.ComposeObject(ctx, dst, src)
+	} else {
+		info, err = client.CopyObject(ctx, dst, src)
+	}
+	if err != nil {
+		return false, err
+	}
+	// 自身へのコピーで古い鍵のバージョンが増えないよう、内容が同じコピー元のバージョンを削除する
+	if stat.VersionID != "" && stat.VersionID != "null" && info.VersionID != stat.VersionID {
+		if err := client.RemoveObject(ctx, bucketName, key, minio.RemoveObjectOptions{VersionID: stat.VersionID}); err != nil {
+			log.Printf("Failed to remove superseded version %s of %s: %v", stat.VersionID, key, err)
+		}
+	}
+
+	indexMu.RLock()
+	entry := metadataIndex[key]
+	indexMu.RUnlock()
+	if entry != nil {
+		indexObject(key, entry.Size, info.LastModified, entry.ContentType, entry.Tags, entry.Image)
+	}
+	modTime = time.Now()
+	return true, nil
+}
@@ -0,0 +1,302 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// 試験用の鍵暗号化鍵を設定する
+func setupEnvelopeKeys(t *testing.T) {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	savedKeys, savedActive, savedKEKs := EnvelopeKeys, EnvelopeActiveKey, keyEncryptionKeys
+	t.Cleanup(func() {
+		EnvelopeKeys, EnvelopeActiveKey, keyEncryptionKeys = savedKeys, savedActive, savedKEKs
+	})
+	EnvelopeKeys, EnvelopeActiveKey = "test:"+hex.EncodeToString(key), ""
+	if err := initEnvelope(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 平文を暗号化し、暗号文と復号に使うデータ鍵の情報を返す
+func sealEnvelope(t *testing.T, plain []byte) ([]byte, *envelopeSealer) {
+	t.Helper()
+	sealer, err := newEnvelopeSealer(bytes.NewReader(plain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := io.ReadAll(sealer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed, sealer
+}
+
+type nopReaderAt struct{ *bytes.Reader }
+
+func (nopReaderAt) Close() error { return nil }
+
+// 暗号文を復号するReader（size は平文のサイズとして扱う値）
+func openEnvelope(t *testing.T, sealed []byte, sealer *envelopeSealer, size int64) *envelopeReader {
+	t.Helper()
+	dataKey, err := unwrapDataKey(sealer.keyID, sealer.wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &envelopeReader{
+		obj:        nopReaderAt{bytes.NewReader(sealed)},
+		aead:       aead,
+		size:       size,
+		cipherSize: int64(len(sealed)),
+		index:      -1,
+		chunk:      make([]byte, 0, envelopeChunkSize),
+		buf:        make([]byte, envelopeChunkSize+envelopeTagSize),
+	}
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	setupEnvelopeKeys(t)
+
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"one byte", 1},
+		{"chunk minus one", envelopeChunkSize - 1},
+		{"exactly one chunk", envelopeChunkSize},
+		{"chunk plus one", envelopeChunkSize + 1},
+		{"exactly three chunks", 3 * envelopeChunkSize},
+		{"several chunks", 3*envelopeChunkSize + 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain := randomBytes(t, tt.size)
+			sealed, sealer := sealEnvelope(t, plain)
+
+			if got, want := int64(len(sealed)), envelopeCipherSize(int64(tt.size)); got != want {
+				t.Fatalf("cipher size = %d, want %d", got, want)
+			}
+			if sealer.size != int64(tt.size) {
+				t.Fatalf("sealer size = %d, want %d", sealer.size, tt.size)
+			}
+
+			got, err := io.ReadAll(openEnvelope(t, sealed, sealer, int64(tt.size)))
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Fatal("decrypted content does not match")
+			}
+		})
+	}
+}
+
+func TestEnvelopeSeek(t *testing.T) {
+	setupEnvelopeKeys(t)
+
+	size := 3*envelopeChunkSize + 100
+	plain := randomBytes(t, size)
+	sealed, sealer := sealEnvelope(t, plain)
+	lastChunk := int64(3 * envelopeChunkSize)
+
+	tests := []struct {
+		name   string
+		offset int64
+		whence int
+		want   int64
+	}{
+		{"start of last chunk", lastChunk, io.SeekStart, lastChunk},
+		{"inside last chunk", lastChunk + 50, io.SeekStart, lastChunk + 50},
+		{"last byte", -1, io.SeekEnd, int64(size) - 1},
+		{"end", 0, io.SeekEnd, int64(size)},
+		{"chunk boundary", envelopeChunkSize, io.SeekStart, envelopeChunkSize},
+		{"inside first chunk", 10, io.SeekStart, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := openEnvelope(t, sealed, sealer, int64(size))
+			// 最後まで読んでから戻る場合も確認する
+			if _, err := io.Copy(io.Discard, reader); err != nil {
+				t.Fatal(err)
+			}
+			pos, err := reader.Seek(tt.offset, tt.whence)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pos != tt.want {
+				t.Fatalf("position = %d, want %d", pos, tt.want)
+			}
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if !bytes.Equal(got, plain[tt.want:]) {
+				t.Fatalf("content after seek to %d does not match", tt.want)
+			}
+		})
+	}
+
+	t.Run("current", func(t *testing.T) {
+		reader := openEnvelope(t, sealed, sealer, int64(size))
+		if _, err := reader.Seek(lastChunk-10, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		head := make([]byte, 20)
+		if _, err := io.ReadFull(reader, head); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(head, plain[lastChunk-10:lastChunk+10]) {
+			t.Fatal("read across the chunk boundary does not match")
+		}
+		if pos, _ := reader.Seek(5, io.SeekCurrent); pos != lastChunk+15 {
+			t.Fatalf("position = %d, want %d", pos, lastChunk+15)
+		}
+	})
+
+	t.Run("negative", func(t *testing.T) {
+		reader := openEnvelope(t, sealed, sealer, int64(size))
+		if _, err := reader.Seek(-1, io.SeekStart); err == nil {
+			t.Fatal("expected an error for a negative position")
+		}
+	})
+}
+
+func TestEnvelopeDetectsTampering(t *testing.T) {
+	setupEnvelopeKeys(t)
+
+	const sealedChunk = envelopeChunkSize + envelopeTagSize
+	size := 3*envelopeChunkSize + 100
+	plain := randomBytes(t, size)
+	sealed, sealer := sealEnvelope(t, plain)
+
+	tests := []struct {
+		name   string
+		modify func(sealed []byte) []byte
+		size   int64
+	}{
+		{
+			name: "flipped bit",
+			modify: func(sealed []byte) []byte {
+				sealed[sealedChunk+10] ^= 1
+				return sealed
+			},
+			size: int64(size),
+		},
+		{
+			name: "flipped tag",
+			modify: func(sealed []byte) []byte {
+				sealed[len(sealed)-1] ^= 1
+				return sealed
+			},
+			size: int64(size),
+		},
+		{
+			name: "reordered chunks",
+			modify: func(sealed []byte) []byte {
+				first := bytes.Clone(sealed[:sealedChunk])
+				copy(sealed, sealed[sealedChunk:2*sealedChunk])
+				copy(sealed[sealedChunk:], first)
+				return sealed
+			},
+			size: int64(size),
+		},
+		{
+			// チャンク単位で末尾を落としても、最終チャンクの印で検出できる
+			name: "truncated at chunk boundary",
+			modify: func(sealed []byte) []byte {
+				return sealed[:3*sealedChunk]
+			},
+			size: 3 * envelopeChunkSize,
+		},
+		{
+			name: "truncated inside last chunk",
+			modify: func(sealed []byte) []byte {
+				return sealed[:len(sealed)-1]
+			},
+			size: int64(size) - 1,
+		},
+		{
+			// 途中のチャンクを抜くと、後ろのチャンクの通し番号が合わなくなる
+			name: "dropped middle chunk",
+			modify: func(sealed []byte) []byte {
+				return append(bytes.Clone(sealed[:sealedChunk]), sealed[2*sealedChunk:3*sealedChunk]...)
+			},
+			size: 2 * envelopeChunkSize,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modified := tt.modify(bytes.Clone(sealed))
+			_, err := io.ReadAll(openEnvelope(t, modified, sealer, tt.size))
+			if !errors.Is(err, ErrEnvelopeCorrupted) {
+				t.Fatalf("err = %v, want %v", err, ErrEnvelopeCorrupted)
+			}
+		})
+	}
+}
+
+func TestEnvelopeWrappedKey(t *testing.T) {
+	setupEnvelopeKeys(t)
+
+	_, sealer := sealEnvelope(t, []byte("data"))
+	if _, err := unwrapDataKey("other", sealer.wrapped); !errors.Is(err, ErrEnvelopeKeyUnavailable) {
+		t.Fatalf("unknown key id: err = %v, want %v", err, ErrEnvelopeKeyUnavailable)
+	}
+
+	// 鍵のIDはAADとして結び付けているため、別のIDの鍵として扱うと復号できない
+	keyEncryptionKeys["renamed"] = keyEncryptionKeys["test"]
+	if _, err := unwrapDataKey("renamed", sealer.wrapped); !errors.Is(err, ErrEnvelopeCorrupted) {
+		t.Fatalf("renamed key id: err = %v, want %v", err, ErrEnvelopeCorrupted)
+	}
+}
+
+func TestEnvelopeServeContentRange(t *testing.T) {
+	setupEnvelopeKeys(t)
+
+	size := 3*envelopeChunkSize + 100
+	plain := randomBytes(t, size)
+	sealed, sealer := sealEnvelope(t, plain)
+
+	// /download と同じく http.ServeContent で範囲指定に応答する
+	start, end := 2*envelopeChunkSize-10, 2*envelopeChunkSize+20
+	req := httptest.NewRequest(http.MethodGet, "/download", nil)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	rec := httptest.NewRecorder()
+	http.ServeContent(rec, req, "file.bin", time.Time{}, openEnvelope(t, sealed, sealer, int64(size)))
+
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusPartialContent)
+	}
+	if want := fmt.Sprintf("bytes %d-%d/%d", start, end, size); rec.Header().Get("Content-Range") != want {
+		t.Fatalf("Content-Range = %q, want %q", rec.Header().Get("Content-Range"), want)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), plain[start:end+1]) {
+		t.Fatal("ranged content does not match")
+	}
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+)
+
+var errUnsupportedContent = errors.New("unsupported content type")
+
+// 全文検索のためのテキスト抽出方式
+const (
+	extractText = "text"
+	extractPDF  = "pdf"
+	extractDOCX = "docx"
+	extractXLSX = "xlsx"
+	extractPPTX = "pptx"
+)
+
+var textExtensions = map[string]string{
+	".txt":      extractText,
+	".md":       extractText,
+	".markdown": extractText,
+	".csv":      extractText,
+	".tsv":      extractText,
+	".log":      extractText,
+	".json":     extractText,
+	".xml":      extractText,
+	".yaml":     extractText,
+	".yml":      extractText,
+	".pdf":      extractPDF,
+	".docx":     extractDOCX,
+	".xlsx":     extractXLSX,
+	".pptx":     extractPPTX,
+}
+
+var textContentTypes = map[string]string{
+	"application/json": extractText,
+	"application/xml":  extractText,
+	"application/yaml": extractText,
+	"application/pdf":  extractPDF,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   extractDOCX,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         extractXLSX,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": extractPPTX,
+}
+
+// コンテンツタイプと拡張子から抽出方式を判定（対象外の場合は ""）
+func extractionKind(key, contentType string) string {
+	if kind, ok := textContentTypes[contentType]; ok {
+		return kind
+	}
+	if strings.HasPrefix(contentType, "text/") {
+		return extractText
+	}
+	return textExtensions[strings.ToLower(path.Ext(key))]
+}
+
+// ファイル内容からテキストを抽出（maxBytesを超える部分は切り捨て）
+func extractContentText(data []byte, kind string, maxBytes int) (string, error) {
+	var text string
+	var err error
+	switch kind {
+	case extractText:
+		text = string(data)
+	case extractPDF:
+		text, err = extractPDFText(data, maxBytes)
+	case extractDOCX:
+		text, err = extractOfficeText(data, maxBytes, func(name string) bool {
+			return name == "word/document.xml"
+		})
+	case extractXLSX:
+		text, err = extractOfficeText(data, maxBytes, func(name string) bool {
+			return name == "xl/sharedStrings.xml" || strings.HasPrefix(name, "xl/worksheets/")
+		})
+	case extractPPTX:
+		text, err = extractOfficeText(data, maxBytes, func(name string) bool {
+			return strings.HasPrefix(name, "ppt/slides/slide") && strings.HasSuffix(name, ".xml")
+		})
+	default:
+		return "", errUnsupportedContent
+	}
+	if err != nil {
+		return "", err
+	}
+	return truncateUTF8(strings.ToValidUTF8(text, ""), maxBytes), nil
+}
+
+func extractPDFText(data []byte, maxBytes int) (string, error) {
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	plain, err := reader.GetPlainText()
+	if err != nil {
+		return "", err
+	}
+	text, err := io.ReadAll(io.LimitReader(plain, int64(maxBytes)))
+	return string(text), err
+}
+
+// Office Open XML（docx/xlsx/pptx）の本文を抽出
+// いずれの形式も本文は <t> 要素に入っているため、対象パーツの <t> を連結する
+func extractOfficeText(data []byte, maxBytes int, include func(name string) bool) (string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+
+	var parts []*zip.File
+	for _, file := range archive.File {
+		if include(file.Name) {
+			parts = append(parts, file)
+		}
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Name < parts[j].Name })
+
+	var sb strings.Builder
+	for _, part := range parts {
+		if sb.Len() >= maxBytes {
+			break
+		}
+		rc, err := part.Open()
+		if err != nil {
+			return "", err
+		}
+		// 展開後のサイズも上限で打ち切る（圧縮爆弾対策）
+		err = collectXMLText(io.LimitReader(rc, int64(maxBytes)), &sb)
+		rc.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return sb.String(), nil
+}
+
+func collectXMLText(r io.Reader, sb *strings.Builder) error {
+	decoder := xml.NewDecoder(r)
+	inText := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// 上限で打ち切った場合などはそこまでの内容を使用
+			if sb.Len() > 0 {
+				return nil
+			}
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			inText = t.Name.Local == "t"
+		case xml.EndElement:
+			inText = false
+			// 段落・セル・共有文字列の区切り
+			switch t.Name.Local {
+			case "p", "si", "c":
+				sb.WriteByte('\n')
+			}
+		case xml.CharData:
+			if inText {
+				sb.Write(t)
+			}
+		}
+	}
+}
+
+// 文字の途中で切れないようにバイト数を制限
+func truncateUTF8(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut]
+}
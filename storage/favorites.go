@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// お気に入りの最大件数
+const maxFavorites = 500
+
+// お気に入り（スター付き）のファイル/フォルダ
+type Favorite struct {
+	Space   string    `json:"space"` // 個人スペースの場合は ""
+	Path    string    `json:"path"`
+	Name    string    `json:"name"`
+	Type    string    `json:"type"` // "file" / "folder"
+	AddedAt time.Time `json:"addedAt"`
+	Exists  bool      `json:"exists"` // 取得時点で存在するか（移動・削除済みの場合は false）
+}
+
+func (f Favorite) same(other Favorite) bool {
+	return f.Space == other.Space && f.Path == other.Path && f.Name == other.Name && f.Type == other.Type
+}
+
+var favoritesMu sync.Mutex
+
+func favoritesKey(userID string) string {
+	return "favorites/" + userID + ".json"
+}
+
+// お気に入り一覧を取得（新しく追加した順）
+// root はお気に入りのスペースから対象のプレフィックスを返す関数（アクセスできない場合は ""）
+func ListFavorites(userID string, root func(space string) string) ([]Favorite, error) {
+	favoritesMu.Lock()
+	var favorites []Favorite
+	err := loadUserData(favoritesKey(userID), &favorites)
+	favoritesMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Favorite{}
+	for _, favorite := range favorites {
+		prefix := root(favorite.Space)
+		if prefix == "" {
+			continue
+		}
+		key := prefix + "/"
+		if favorite.Path != "" {
+			key += favorite.Path + "/"
+		}
+		key += favorite.Name
+		if favorite.Type == "folder" {
+			// .keepのみのフォルダはインデックス対象外のためバケットを確認
+			exists, err := PrefixExists(key + "/")
+			favorite.Exists = err == nil && exists
+		} else {
+			favorite.Exists = indexHasKey(key)
+		}
+		result = append(result, favorite)
+	}
+	return result, nil
+}
+
+// お気に入りに追加（登録済みの場合は先頭に移動）
+func AddFavorite(userID string, favorite Favorite) error {
+	favoritesMu.Lock()
+	defer favoritesMu.Unlock()
+
+	var favorites []Favorite
+	if err := loadUserData(favoritesKey(userID), &favorites); err != nil {
+		return err
+	}
+
+	favorite.Path = strings.Trim(favorite.Path, "/")
+	favorite.AddedAt = time.Now()
+	favorite.Exists = false
+	updated := []Favorite{favorite}
+	for _, existing := range favorites {
+		if !existing.same(favorite) {
+			updated = append(updated, existing)
+		}
+	}
+	if len(updated) > maxFavorites {
+		updated = updated[:maxFavorites]
+	}
+	return saveUserData(favoritesKey(userID), updated)
+}
+
+// お気に入りから削除（登録されていない場合は ErrNotFound）
+func RemoveFavorite(userID string, favorite Favorite) error {
+	favoritesMu.Lock()
+	defer favoritesMu.Unlock()
+
+	var favorites []Favorite
+	if err := loadUserData(favoritesKey(userID), &favorites); err != nil {
+		return err
+	}
+
+	favorite.Path = strings.Trim(favorite.Path, "/")
+	updated := []Favorite{}
+	for _, existing := range favorites {
+		if !existing.same(favorite) {
+			updated = append(updated, existing)
+		}
+	}
+	if len(updated) == len(favorites) {
+		return ErrNotFound
+	}
+	return saveUserData(favoritesKey(userID), updated)
+}
@@ -0,0 +1,342 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/lang/cjk"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/minio/minio-go/v7"
+)
+
+var ErrFullTextUnavailable = errors.New("full-text index is not available")
+
+var (
+	// 全文検索インデックスの保存先ディレクトリ（FULLTEXT_INDEX_PATH、未設定の場合はメモリ上のみ）
+	FullTextIndexPath = os.Getenv("FULLTEXT_INDEX_PATH")
+	// インデックス対象とする最大ファイルサイズ（FULLTEXT_MAX_FILE_SIZE、既定50MiB）
+	FullTextMaxFileSize = envInt64("FULLTEXT_MAX_FILE_SIZE", 50<<20)
+	// 1ファイルから抽出するテキストの上限（FULLTEXT_MAX_TEXT_SIZE、既定1MiB）
+	FullTextMaxTextSize = envInt64("FULLTEXT_MAX_TEXT_SIZE", 1<<20)
+	// テキスト抽出を行うワーカー数（FULLTEXT_WORKERS、既定2）
+	FullTextWorkers = envInt64("FULLTEXT_WORKERS", 2)
+)
+
+// インデックス待ちキューの長さ（溢れた分は定期的な再同期で補う）
+const fullTextQueueSize = 10000
+
+// 全文検索インデックスの1文書
+type fullTextDocument struct {
+	Root    string `json:"root"` // 閲覧範囲（"user123" / "teams/dev"）
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	Version string `json:"version"` // インデックス時点のサイズと更新日時
+}
+
+// 全文検索の条件
+type FullTextQuery struct {
+	Roots  []string // 閲覧可能なスペース（"user123" / "teams/dev"）
+	Query  string
+	Limit  int
+	Offset int
+}
+
+var (
+	fullTextIndex  bleve.Index
+	fullTextQueue  chan string
+	fullTextMu     sync.Mutex
+	fullTextQueued = map[string]bool{}
+)
+
+// キーの閲覧範囲（個人スペースはユーザーID、チームスペースは teams/<グループ>）
+func fullTextRoot(key string) string {
+	parts := strings.SplitN(key, "/", 3)
+	if parts[0] == "teams" && len(parts) >= 2 {
+		return "teams/" + parts[1]
+	}
+	return parts[0]
+}
+
+func fullTextVersion(entry *indexEntry) string {
+	return fmt.Sprintf("%d-%d", entry.Size, entry.LastModified.UnixNano())
+}
+
+func newFullTextMapping() *mapping.IndexMappingImpl {
+	// 日本語を含むため、CJK文字はbigramで分割する
+	content := bleve.NewTextFieldMapping()
+	content.Analyzer = cjk.AnalyzerName
+	content.Store = true // スニペット生成のため
+	content.IncludeTermVectors = true
+
+	name := bleve.NewTextFieldMapping()
+	name.Analyzer = cjk.AnalyzerName
+	name.Store = false
+
+	root := bleve.NewKeywordFieldMapping()
+	root.Store = false
+
+	version := bleve.NewKeywordFieldMapping()
+	version.Index = false
+
+	doc := bleve.NewDocumentMapping()
+	doc.AddFieldMappingsAt("root", root)
+	doc.AddFieldMappingsAt("name", name)
+	doc.AddFieldMappingsAt("content", content)
+	doc.AddFieldMappingsAt("version", version)
+
+	indexMapping := bleve.NewIndexMapping()
+	indexMapping.DefaultMapping = doc
+	return indexMapping
+}
+
+// 全文検索インデックスを開き、テキスト抽出ワーカーと既存ファイルとの同期を開始
+func StartFullTextIndexer() error {
+	var index bleve.Index
+	var err error
+	if FullTextIndexPath == "" {
+		index, err = bleve.NewMemOnly(newFullTextMapping())
+	} else {
+		index, err = bleve.Open(FullTextIndexPath)
+		if errors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+			index, err = bleve.New(FullTextIndexPath, newFullTextMapping())
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	fullTextIndex = index
+	fullTextQueue = make(chan string, fullTextQueueSize)
+	for i := int64(0); i < max(FullTextWorkers, 1); i++ {
+		go runFullTextWorker()
+	}
+
+	go func() {
+		if err := syncFullText(); err != nil {
+			log.Printf("Full-text sync error: %v", err)
+		}
+	}()
+	return nil
+}
+
+// キーを全文検索のインデックス待ちに追加（wait=false の場合はキューが満杯なら諦める）
+func enqueueFullText(key string, wait bool) {
+	if fullTextQueue == nil || !isIndexable(key) {
+		return
+	}
+
+	fullTextMu.Lock()
+	if fullTextQueued[key] {
+		fullTextMu.Unlock()
+		return
+	}
+	fullTextQueued[key] = true
+	fullTextMu.Unlock()
+
+	if wait {
+		fullTextQueue <- key
+		return
+	}
+	select {
+	case fullTextQueue <- key:
+	default:
+		fullTextMu.Lock()
+		delete(fullTextQueued, key)
+		fullTextMu.Unlock()
+		log.Printf("Full-text queue full, %s will be indexed on next sync", key)
+	}
+}
+
+func runFullTextWorker() {
+	for key := range fullTextQueue {
+		if err := indexFullText(key); err != nil {
+			log.Printf("Full-text indexing of %s failed: %v", key, err)
+		}
+	}
+}
+
+// オブジェクトの現在の状態をインデックスに反映（削除済み・対象外の場合は文書を削除）
+func indexFullText(key string) (err error) {
+	// 不正なファイルで抽出処理がpanicしてもワーカーを止めない
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic during extraction: %v", r)
+		}
+	}()
+
+	fullTextMu.Lock()
+	delete(fullTextQueued, key)
+	fullTextMu.Unlock()
+
+	indexMu.RLock()
+	entry := metadataIndex[key]
+	indexMu.RUnlock()
+
+	if entry == nil || entry.Size > FullTextMaxFileSize {
+		return fullTextIndex.Delete(key)
+	}
+	kind := extractionKind(key, entry.ContentType)
+	if kind == "" {
+		return fullTextIndex.Delete(key)
+	}
+
+	obj, info, err := getObject(context.Background(), key, minio.GetObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return fullTextIndex.Delete(key)
+		}
+		return err
+	}
+	// 暗号化されたファイルの本文はインデックスに残さない
+	if _, ok := envelopeFromMetadata(info.UserMetadata); ok {
+		obj.Close()
+		return fullTextIndex.Delete(key)
+	}
+	reader, _, err := decompressObject(obj, info)
+	if err != nil {
+		obj.Close()
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, FullTextMaxFileSize))
+	reader.Close()
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return fullTextIndex.Delete(key)
+		}
+		return err
+	}
+
+	text, err := extractContentText(data, kind, int(FullTextMaxTextSize))
+	if err != nil {
+		fullTextIndex.Delete(key)
+		return err
+	}
+
+	return fullTextIndex.Index(key, fullTextDocument{
+		Root:    fullTextRoot(key),
+		Name:    path.Base(key),
+		Content: text,
+		Version: fullTextVersion(entry),
+	})
+}
+
+// メタデータインデックスと全文検索インデックスを突き合わせ、差分をインデックス待ちに追加
+func syncFullText() error {
+	if fullTextIndex == nil {
+		return nil
+	}
+
+	// インデックス済みの文書とそのバージョンを取得
+	const pageSize = 1000
+	indexed := map[string]string{}
+	var after []string
+	for {
+		req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), pageSize, 0, false)
+		req.Fields = []string{"version"}
+		req.SortBy([]string{"_id"})
+		if after != nil {
+			req.SetSearchAfter(after)
+		}
+		result, err := fullTextIndex.Search(req)
+		if err != nil {
+			return err
+		}
+		for _, hit := range result.Hits {
+			version, _ := hit.Fields["version"].(string)
+			indexed[hit.ID] = version
+		}
+		if len(result.Hits) < pageSize {
+			break
+		}
+		after = []string{result.Hits[len(result.Hits)-1].ID}
+	}
+
+	var stale []string
+	indexMu.RLock()
+	for key, entry := range metadataIndex {
+		if extractionKind(key, entry.ContentType) == "" || entry.Size > FullTextMaxFileSize {
+			continue
+		}
+		if version, ok := indexed[key]; !ok || version != fullTextVersion(entry) {
+			stale = append(stale, key)
+		}
+		delete(indexed, key)
+	}
+	indexMu.RUnlock()
+
+	// 残りは削除済み・対象外になった文書
+	for key := range indexed {
+		stale = append(stale, key)
+	}
+	for _, key := range stale {
+		enqueueFullText(key, true)
+	}
+	return nil
+}
+
+// 閲覧可能なスペース内のファイルを本文・ファイル名で検索（スコア順）
+func SearchContent(q FullTextQuery) ([]FullTextHit, uint64, error) {
+	if fullTextIndex == nil {
+		return nil, 0, ErrFullTextUnavailable
+	}
+	if len(q.Roots) == 0 {
+		return []FullTextHit{}, 0, nil
+	}
+
+	content := bleve.NewMatchQuery(q.Query)
+	content.SetField("content")
+	name := bleve.NewMatchQuery(q.Query)
+	name.SetField("name")
+	name.SetBoost(2)
+
+	roots := bleve.NewDisjunctionQuery()
+	for _, root := range q.Roots {
+		term := bleve.NewTermQuery(strings.Trim(root, "/"))
+		term.SetField("root")
+		roots.AddQuery(term)
+	}
+
+	req := bleve.NewSearchRequestOptions(
+		bleve.NewConjunctionQuery(bleve.NewDisjunctionQuery(content, name), roots),
+		q.Limit, q.Offset, false)
+	req.Highlight = bleve.NewHighlightWithStyle("html")
+	req.Highlight.AddField("content")
+
+	result, err := fullTextIndex.Search(req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	hits := make([]FullTextHit, 0, len(result.Hits))
+	for _, hit := range result.Hits {
+		indexMu.RLock()
+		entry := metadataIndex[hit.ID]
+		indexMu.RUnlock()
+		if entry == nil {
+			// 削除済みのファイルは結果から除き、インデックスからも削除
+			enqueueFullText(hit.ID, false)
+			continue
+		}
+
+		hits = append(hits, FullTextHit{
+			SearchHit: toSearchHit(listEntry{
+				Size:         entry.Size,
+				LastModified: entry.LastModified,
+				ContentType:  entry.ContentType,
+				Tags:         entry.Tags,
+				key:          entry.Key,
+			}),
+			Score:    hit.Score,
+			Snippets: hit.Fragments["content"],
+		})
+	}
+	return hits, result.Total, nil
+}
@@ -0,0 +1,468 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"io"
+	"maps"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// アップロード時に画像の位置情報を既定で削除するか（IMAGE_STRIP_LOCATION、既定false。stripLocation で上書き可）
+	ImageStripLocation = envBool("IMAGE_STRIP_LOCATION", false)
+	// 位置情報を削除する画像の最大サイズ（IMAGE_METADATA_MAX_SIZE、既定50MiB。超える場合はそのまま保存）
+	ImageMetadataMaxSize = envInt64("IMAGE_METADATA_MAX_SIZE", 50<<20)
+)
+
+// 画像のメタデータを保存するメタデータのキー（x-amz-meta-image-*）
+const (
+	imageWidthKey  = "Image-Width"
+	imageHeightKey = "Image-Height"
+	imageCameraKey = "Image-Camera"
+	imageTakenKey  = "Image-Taken"
+)
+
+// 位置情報を削除しない場合に読み取る先頭部分のサイズ（EXIFは通常ファイルの先頭にある）
+const imageMetadataHeadSize = 256 << 10
+
+// 撮影日時の形式（タイムゾーンが記録されていない場合はオフセットなし）
+const imageTakenLayout = "2006-01-02T15:04:05"
+
+// 画像から抽出したメタデータ
+type ImageMetadata struct {
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Camera string `json:"camera,omitempty"` // メーカーと機種（EXIFの Make / Model）
+	Taken  string `json:"taken,omitempty"`  // 撮影日時（"2006-01-02T15:04:05"、オフセットが記録されている場合は付加）
+}
+
+// 撮影日時を時刻に変換（オフセットが記録されていない場合はUTCとみなす）
+func (m *ImageMetadata) TakenTime() (time.Time, bool) {
+	if m == nil || m.Taken == "" {
+		return time.Time{}, false
+	}
+	if t, err := time.Parse(time.RFC3339, m.Taken); err == nil {
+		return t, true
+	}
+	t, err := time.Parse(imageTakenLayout, m.Taken)
+	return t, err == nil
+}
+
+// メタデータを付加したユーザー定義メタデータ
+func (m *ImageMetadata) withMetadata(metadata map[string]string) map[string]string {
+	merged := maps.Clone(metadata)
+	if merged == nil {
+		merged = map[string]string{}
+	}
+	merged[imageWidthKey] = strconv.Itoa(m.Width)
+	merged[imageHeightKey] = strconv.Itoa(m.Height)
+	if m.Camera != "" {
+		merged[imageCameraKey] = m.Camera
+	}
+	if m.Taken != "" {
+		merged[imageTakenKey] = m.Taken
+	}
+	return merged
+}
+
+// ユーザー定義メタデータから画像のメタデータを取得（画像でない場合は nil）
+func imageFromMetadata(metadata map[string]string) *ImageMetadata {
+	width, err := strconv.Atoi(metadata[imageWidthKey])
+	if err != nil {
+		return nil
+	}
+	height, err := strconv.Atoi(metadata[imageHeightKey])
+	if err != nil {
+		return nil
+	}
+	return &ImageMetadata{
+		Width:  width,
+		Height: height,
+		Camera: metadata[imageCameraKey],
+		Taken:  metadata[imageTakenKey],
+	}
+}
+
+// 保存前に画像のメタデータを抽出し、指定された場合は位置情報を削除する
+// 削除すると内容が変わるため、クライアントが指定したチェックサムは先に元のデータで検証する
+func prepareImage(data io.ReadSeeker, size int64, opts UploadOptions) (io.Reader, int64, UploadOptions, error) {
+	mediaType, _, err := mime.ParseMediaType(opts.ContentType)
+	if err != nil || !strings.HasPrefix(mediaType, "image/") || size < 0 {
+		return data, size, opts, nil
+	}
+	mediaType = strings.ToLower(mediaType)
+
+	strip := opts.StripLocation && (mediaType == "image/jpeg" || mediaType == "image/png") && size <= ImageMetadataMaxSize
+	if !strip {
+		head, err := io.ReadAll(io.LimitReader(data, imageMetadataHeadSize))
+		if err != nil {
+			return nil, 0, opts, err
+		}
+		if _, err := data.Seek(0, io.SeekStart); err != nil {
+			return nil, 0, opts, err
+		}
+		if meta := extractImageMetadata(head, mediaType); meta != nil {
+			opts.Metadata = meta.withMetadata(opts.Metadata)
+		}
+		return data, size, opts, nil
+	}
+
+	if err := VerifyChecksums(data, opts.Checksums); err != nil {
+		return nil, 0, opts, err
+	}
+	opts.Checksums = Checksums{}
+	content, err := io.ReadAll(data)
+	if err != nil {
+		return nil, 0, opts, err
+	}
+	content = stripImageLocation(content, mediaType)
+	if meta := extractImageMetadata(content, mediaType); meta != nil {
+		opts.Metadata = meta.withMetadata(opts.Metadata)
+	}
+	return bytes.NewReader(content), int64(len(content)), opts, nil
+}
+
+// 画像の縦横のサイズとEXIFの撮影情報を抽出（読み取れない場合は nil）
+func extractImageMetadata(data []byte, mediaType string) *ImageMetadata {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	meta := &ImageMetadata{Width: config.Width, Height: config.Height}
+
+	var exif []byte
+	switch mediaType {
+	case "image/jpeg":
+		exif = jpegExif(data)
+	case "image/png":
+		exif = pngExif(data)
+	}
+	if t := newTIFF(exif); t != nil {
+		t.readMetadata(meta)
+	}
+	return meta
+}
+
+// JPEGのEXIF（APP1セグメント内のTIFF）を取得
+func jpegExif(data []byte) []byte {
+	var exif []byte
+	walkJPEG(data, func(marker byte, segment []byte) bool {
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			exif = segment[6:]
+			return false
+		}
+		return true
+	})
+	return exif
+}
+
+// PNGのEXIF（eXIfチャンク）を取得
+func pngExif(data []byte) []byte {
+	var exif []byte
+	walkPNG(data, func(chunkType string, chunk []byte) bool {
+		if chunkType == "eXIf" {
+			exif = chunk
+			return false
+		}
+		return true
+	})
+	return exif
+}
+
+// JPEGのセグメントを順に処理（fn が false を返すか画像データに達したら終了）
+// segment は長さフィールドを除いたセグメントの内容（元のデータを参照する）
+func walkJPEG(data []byte, fn func(marker byte, segment []byte) bool) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return
+	}
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			return
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return
+		}
+		if !fn(marker, data[i+4:i+2+length]) {
+			return
+		}
+		i += 2 + length
+	}
+}
+
+// PNGのチャンクを順に処理（chunk はチャンクのデータ部分、元のデータを参照する）
+func walkPNG(data []byte, fn func(chunkType string, chunk []byte) bool) {
+	if !bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")) {
+		return
+	}
+	for i := 8; i+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		if length < 0 || i+12+length > len(data) {
+			return
+		}
+		if !fn(string(data[i+4:i+8]), data[i+8:i+8+length]) {
+			return
+		}
+		i += 12 + length
+	}
+}
+
+// XMP（位置情報を含むことがある）のJPEGセグメントか
+func isJPEGXMP(marker byte, segment []byte) bool {
+	return marker == 0xE1 && (bytes.HasPrefix(segment, []byte("http://ns.adobe.com/xap/1.0/\x00")) ||
+		bytes.HasPrefix(segment, []byte("http://ns.adobe.com/xmp/extension/\x00")))
+}
+
+// XMPのPNGテキストチャンクか
+func isPNGXMP(chunkType string, chunk []byte) bool {
+	return (chunkType == "iTXt" || chunkType == "tEXt" || chunkType == "zTXt") &&
+		bytes.HasPrefix(chunk, []byte("XML:com.adobe.xmp\x00"))
+}
+
+// 画像から位置情報を削除（EXIFのGPS情報を消去し、XMPは取り除く。画素データは変更しない）
+func stripImageLocation(data []byte, mediaType string) []byte {
+	var out bytes.Buffer
+	switch mediaType {
+	case "image/jpeg":
+		if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+			return data
+		}
+		out.Write(data[:2])
+		pos := 2
+		walkJPEG(data, func(marker byte, segment []byte) bool {
+			start := pos
+			pos += 4 + len(segment)
+			if isJPEGXMP(marker, segment) {
+				return true
+			}
+			if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+				if t := newTIFF(segment[6:]); t != nil {
+					t.clearGPS()
+				}
+			}
+			out.Write(data[start:pos])
+			return true
+		})
+		out.Write(data[pos:])
+
+	case "image/png":
+		if len(data) < 8 {
+			return data
+		}
+		out.Write(data[:8])
+		pos := 8
+		walkPNG(data, func(chunkType string, chunk []byte) bool {
+			start := pos
+			pos += 12 + len(chunk)
+			if isPNGXMP(chunkType, chunk) {
+				return true
+			}
+			if chunkType == "eXIf" {
+				if t := newTIFF(chunk); t != nil && t.clearGPS() {
+					// チャンクの内容を変更したためCRCを計算し直す
+					binary.BigEndian.PutUint32(data[pos-4:], crc32.ChecksumIEEE(data[start+4:pos-4]))
+				}
+			}
+			out.Write(data[start:pos])
+			return true
+		})
+		out.Write(data[pos:])
+
+	default:
+		return data
+	}
+	return out.Bytes()
+}
+
+// EXIFのTIFF構造（IFDのエントリを直接読み書きする）
+type tiffData struct {
+	data  []byte
+	order binary.ByteOrder
+	ifd0  int
+}
+
+// IFDのエントリ
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	pos   int // 値（4バイト以下）またはオフセットの位置
+}
+
+// TIFFのタグ
+const (
+	tiffTagMake             = 0x010F
+	tiffTagModel            = 0x0110
+	tiffTagOrientation      = 0x0112
+	tiffTagDateTime         = 0x0132
+	tiffTagExifIFD          = 0x8769
+	tiffTagGPSIFD           = 0x8825
+	tiffTagDateTimeOriginal = 0x9003
+	tiffTagOffsetOriginal   = 0x9011
+)
+
+// 型ごとの1要素のバイト数
+var tiffTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+func newTIFF(data []byte) *tiffData {
+	if len(data) < 8 {
+		return nil
+	}
+	t := &tiffData{data: data}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil
+	}
+	if t.order.Uint16(data[2:]) != 42 {
+		return nil
+	}
+	t.ifd0 = int(t.order.Uint32(data[4:]))
+	return t
+}
+
+// IFDのエントリ一覧（範囲外を指す場合は nil）
+func (t *tiffData) entries(offset int) []tiffEntry {
+	if offset < 8 || offset+2 > len(t.data) {
+		return nil
+	}
+	count := int(t.order.Uint16(t.data[offset:]))
+	if offset+2+count*12 > len(t.data) {
+		return nil
+	}
+	entries := make([]tiffEntry, 0, count)
+	for i := 0; i < count; i++ {
+		pos := offset + 2 + i*12
+		entries = append(entries, tiffEntry{
+			tag:   t.order.Uint16(t.data[pos:]),
+			typ:   t.order.Uint16(t.data[pos+2:]),
+			count: t.order.Uint32(t.data[pos+4:]),
+			pos:   pos + 8,
+		})
+	}
+	return entries
+}
+
+// エントリの値のバイト列（範囲外の場合は nil）
+func (t *tiffData) value(e tiffEntry) []byte {
+	size := tiffTypeSizes[e.typ] * int(e.count)
+	if size <= 0 || e.count > uint32(len(t.data)) {
+		return nil
+	}
+	start := e.pos
+	if size > 4 {
+		start = int(t.order.Uint32(t.data[e.pos:]))
+	}
+	if start < 0 || start+size > len(t.data) {
+		return nil
+	}
+	return t.data[start : start+size]
+}
+
+// ASCII文字列の値（メタデータとして保存できない文字は取り除く）
+func (t *tiffData) stringValue(e tiffEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	value, _, _ := bytes.Cut(t.value(e), []byte{0})
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return -1
+		}
+		return r
+	}, string(value)))
+}
+
+func (t *tiffData) uintValue(e tiffEntry) (int, bool) {
+	value := t.value(e)
+	switch {
+	case e.typ == 3 && len(value) >= 2:
+		return int(t.order.Uint16(value)), true
+	case e.typ == 4 && len(value) >= 4:
+		return int(t.order.Uint32(value)), true
+	}
+	return 0, false
+}
+
+// メーカー・機種・撮影日時・向きを読み取る
+func (t *tiffData) readMetadata(meta *ImageMetadata) {
+	var maker, model, dateTime, original, offset string
+	var exifIFD int
+	for _, e := range t.entries(t.ifd0) {
+		switch e.tag {
+		case tiffTagMake:
+			maker = t.stringValue(e)
+		case tiffTagModel:
+			model = t.stringValue(e)
+		case tiffTagDateTime:
+			dateTime = t.stringValue(e)
+		case tiffTagExifIFD:
+			exifIFD, _ = t.uintValue(e)
+		case tiffTagOrientation:
+			// 90度回転して表示する画像は縦横を入れ替える
+			if orientation, ok := t.uintValue(e); ok && orientation >= 5 && orientation <= 8 {
+				meta.Width, meta.Height = meta.Height, meta.Width
+			}
+		}
+	}
+	for _, e := range t.entries(exifIFD) {
+		switch e.tag {
+		case tiffTagDateTimeOriginal:
+			original = t.stringValue(e)
+		case tiffTagOffsetOriginal:
+			offset = t.stringValue(e)
+		}
+	}
+
+	// 機種名にメーカー名が含まれる場合は重ねない（"Canon" + "Canon EOS R5"）
+	camera := model
+	if maker != "" && !strings.HasPrefix(strings.ToLower(model), strings.ToLower(maker)) {
+		camera = strings.TrimSpace(maker + " " + model)
+	}
+	meta.Camera = truncateUTF8(camera, 128)
+
+	if original == "" {
+		original = dateTime
+	}
+	if taken, err := time.Parse("2006:01:02 15:04:05", original); err == nil {
+		meta.Taken = taken.Format(imageTakenLayout)
+		if zone, err := time.Parse("-07:00", offset); err == nil {
+			meta.Taken = taken.Format(imageTakenLayout) + zone.Format("-07:00")
+		}
+	}
+}
+
+// GPS情報のIFDを空にする（長さを変えないよう、エントリと値を0で埋める）
+// 変更した場合は true
+func (t *tiffData) clearGPS() bool {
+	for _, e := range t.entries(t.ifd0) {
+		if e.tag != tiffTagGPSIFD {
+			continue
+		}
+		offset, ok := t.uintValue(e)
+		entries := t.entries(offset)
+		if !ok || entries == nil {
+			return false
+		}
+		for _, gps := range entries {
+			if value := t.value(gps); value != nil {
+				clear(value)
+			}
+		}
+		// エントリ数を0にし、エントリと次のIFDへのオフセットを消去する
+		end := min(offset+2+len(entries)*12+4, len(t.data))
+		clear(t.data[offset:end])
+		return true
+	}
+	return false
+}
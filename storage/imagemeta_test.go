@@ -0,0 +1,335 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// 試験用のIFDエントリ（ifd が0以外の場合は ifds[ifd] へのオフセットを値とする）
+type testTIFFEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+	ifd   int
+}
+
+func asciiEntry(tag uint16, s string) testTIFFEntry {
+	return testTIFFEntry{tag: tag, typ: 2, count: uint32(len(s) + 1), value: []byte(s + "\x00")}
+}
+
+func rationalEntry(order binary.ByteOrder, tag uint16, values ...uint32) testTIFFEntry {
+	value := make([]byte, len(values)*8)
+	for i, v := range values {
+		order.PutUint32(value[i*8:], v)
+		order.PutUint32(value[i*8+4:], 1)
+	}
+	return testTIFFEntry{tag: tag, typ: 5, count: uint32(len(values)), value: value}
+}
+
+func pointerEntry(tag uint16, ifd int) testTIFFEntry {
+	return testTIFFEntry{tag: tag, typ: 4, count: 1, ifd: ifd}
+}
+
+// IFDを順に並べたTIFFを作成する
+// 位置情報として消去されるべき範囲（GPSのIFDと、その4バイトを超える値）も返す
+func buildTIFF(order binary.ByteOrder, ifds [][]testTIFFEntry, gpsIFD int) ([]byte, [][2]int) {
+	offsets := make([]int, len(ifds))
+	pos := 8
+	for i, entries := range ifds {
+		offsets[i] = pos
+		pos += 2 + len(entries)*12 + 4
+	}
+
+	data := make([]byte, pos)
+	if order == binary.LittleEndian {
+		copy(data, "II")
+	} else {
+		copy(data, "MM")
+	}
+	order.PutUint16(data[2:], 42)
+	order.PutUint32(data[4:], uint32(offsets[0]))
+
+	var gpsRanges [][2]int
+	for i, entries := range ifds {
+		p := offsets[i]
+		order.PutUint16(data[p:], uint16(len(entries)))
+		if i == gpsIFD {
+			gpsRanges = append(gpsRanges, [2]int{p, p + 2 + len(entries)*12 + 4})
+		}
+		for j, e := range entries {
+			ep := p + 2 + j*12
+			order.PutUint16(data[ep:], e.tag)
+			order.PutUint16(data[ep+2:], e.typ)
+			order.PutUint32(data[ep+4:], e.count)
+			switch {
+			case e.ifd != 0:
+				order.PutUint32(data[ep+8:], uint32(offsets[e.ifd]))
+			case len(e.value) <= 4:
+				copy(data[ep+8:], e.value)
+			default:
+				order.PutUint32(data[ep+8:], uint32(len(data)))
+				if i == gpsIFD {
+					gpsRanges = append(gpsRanges, [2]int{len(data), len(data) + len(e.value)})
+				}
+				data = append(data, e.value...)
+			}
+		}
+	}
+	return data, gpsRanges
+}
+
+// メーカー・機種・撮影日時・GPS情報を含むEXIF
+func sampleExif(order binary.ByteOrder) ([]byte, [][2]int) {
+	return buildTIFF(order, [][]testTIFFEntry{
+		{
+			asciiEntry(tiffTagMake, "Canon"),
+			asciiEntry(tiffTagModel, "EOS R5"),
+			asciiEntry(tiffTagDateTime, "2024:05:06 10:00:00"),
+			pointerEntry(tiffTagExifIFD, 1),
+			pointerEntry(tiffTagGPSIFD, 2),
+		},
+		{
+			asciiEntry(tiffTagDateTimeOriginal, "2024:05:06 07:08:09"),
+			asciiEntry(tiffTagOffsetOriginal, "+09:00"),
+		},
+		{
+			asciiEntry(0x0001, "N"), // GPSLatitudeRef
+			rationalEntry(order, 0x0002, 35, 39, 29),
+			asciiEntry(0x0003, "E"), // GPSLongitudeRef
+			rationalEntry(order, 0x0004, 139, 44, 28),
+			asciiEntry(0x001D, "2024:05:06"), // GPSDateStamp
+		},
+	}, 2)
+}
+
+const sampleXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF><rdf:Description exif:GPSLatitude="35,39.48N"/></rdf:RDF></x:xmpmeta>`
+
+func sampleImage() image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 24, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 24; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x * 10), G: uint8(y * 15), B: uint8(x * y), A: 255})
+		}
+	}
+	return img
+}
+
+// PNGのシグネチャとIHDRチャンクの長さ（追加のチャンクはこの後に挿入する）
+const ihdrEnd = 8 + 12 + 13
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+func pngChunk(chunkType string, payload []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, payload...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// GPS情報の範囲がすべて0で、それ以外はEXIFが変わっていないことを確認する
+func checkGPSCleared(t *testing.T, original, stripped []byte, gpsRanges [][2]int) {
+	t.Helper()
+	if len(stripped) != len(original) {
+		t.Fatalf("exif length = %d, want %d", len(stripped), len(original))
+	}
+	cleared := make([]bool, len(original))
+	for _, r := range gpsRanges {
+		for i := r[0]; i < r[1]; i++ {
+			cleared[i] = true
+		}
+	}
+	for i := range original {
+		if cleared[i] && stripped[i] != 0 {
+			t.Fatalf("GPS byte at %d = %#x, want 0", i, stripped[i])
+		}
+		if !cleared[i] && stripped[i] != original[i] {
+			t.Fatalf("byte at %d outside the GPS data changed", i)
+		}
+	}
+}
+
+func checkSampleMetadata(t *testing.T, meta *ImageMetadata) {
+	t.Helper()
+	want := ImageMetadata{Width: 24, Height: 16, Camera: "Canon EOS R5", Taken: "2024-05-06T07:08:09+09:00"}
+	if meta == nil || *meta != want {
+		t.Fatalf("metadata = %+v, want %+v", meta, want)
+	}
+}
+
+var byteOrders = []struct {
+	name  string
+	order binary.ByteOrder
+}{
+	{"II", binary.LittleEndian},
+	{"MM", binary.BigEndian},
+}
+
+func TestStripImageLocationJPEG(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, sampleImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bo := range byteOrders {
+		t.Run(bo.name, func(t *testing.T) {
+			exif, gpsRanges := sampleExif(bo.order)
+			xmp := append([]byte("http://ns.adobe.com/xap/1.0/\x00"), sampleXMP...)
+
+			var input bytes.Buffer
+			input.Write(encoded.Bytes()[:2])
+			input.Write(jpegSegment(0xE1, append([]byte("Exif\x00\x00"), exif...)))
+			input.Write(jpegSegment(0xE1, xmp))
+			input.Write(encoded.Bytes()[2:])
+
+			checkSampleMetadata(t, extractImageMetadata(input.Bytes(), "image/jpeg"))
+
+			stripped := stripImageLocation(bytes.Clone(input.Bytes()), "image/jpeg")
+
+			if bytes.Contains(stripped, []byte("ns.adobe.com/xap")) {
+				t.Fatal("XMP segment was not removed")
+			}
+			strippedExif := jpegExif(stripped)
+			checkGPSCleared(t, exif, strippedExif, gpsRanges)
+
+			// 画像データ（量子化テーブル以降）はバイト単位で同じであること
+			headerSize := 2 + 4 + 6 + len(exif)
+			if !bytes.Equal(stripped[headerSize:], encoded.Bytes()[2:]) {
+				t.Fatal("image data changed")
+			}
+			if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+				t.Fatalf("decode stripped image: %v", err)
+			}
+			checkSampleMetadata(t, extractImageMetadata(stripped, "image/jpeg"))
+		})
+	}
+}
+
+func TestStripImageLocationPNG(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, sampleImage()); err != nil {
+		t.Fatal(err)
+	}
+	for _, bo := range byteOrders {
+		t.Run(bo.name, func(t *testing.T) {
+			exif, gpsRanges := sampleExif(bo.order)
+			comment := pngChunk("tEXt", []byte("Comment\x00kept"))
+
+			var input bytes.Buffer
+			input.Write(encoded.Bytes()[:ihdrEnd])
+			input.Write(pngChunk("eXIf", exif))
+			input.Write(pngChunk("iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), sampleXMP...)))
+			input.Write(comment)
+			input.Write(encoded.Bytes()[ihdrEnd:])
+
+			checkSampleMetadata(t, extractImageMetadata(input.Bytes(), "image/png"))
+
+			stripped := stripImageLocation(bytes.Clone(input.Bytes()), "image/png")
+
+			if bytes.Contains(stripped, []byte("XML:com.adobe.xmp")) {
+				t.Fatal("XMP chunk was not removed")
+			}
+			if !bytes.Contains(stripped, comment) {
+				t.Fatal("unrelated text chunk was removed")
+			}
+			strippedExif := pngExif(stripped)
+			checkGPSCleared(t, exif, strippedExif, gpsRanges)
+
+			// 書き換えたeXIfチャンクのCRCが正しいこと
+			chunkStart := ihdrEnd
+			chunkEnd := chunkStart + 12 + len(exif)
+			crc := binary.BigEndian.Uint32(stripped[chunkEnd-4:])
+			if want := crc32.ChecksumIEEE(stripped[chunkStart+4 : chunkEnd-4]); crc != want {
+				t.Fatalf("eXIf CRC = %#x, want %#x", crc, want)
+			}
+			if !bytes.Equal(stripped[chunkEnd+len(comment):], encoded.Bytes()[ihdrEnd:]) {
+				t.Fatal("image data changed")
+			}
+
+			// 標準のデコーダはチャンクのCRCを検証する
+			original, err := png.Decode(bytes.NewReader(encoded.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := png.Decode(bytes.NewReader(stripped))
+			if err != nil {
+				t.Fatalf("decode stripped image: %v", err)
+			}
+			for y := 0; y < 16; y++ {
+				for x := 0; x < 24; x++ {
+					if decoded.At(x, y) != original.At(x, y) {
+						t.Fatalf("pixel (%d, %d) differs", x, y)
+					}
+				}
+			}
+			checkSampleMetadata(t, extractImageMetadata(stripped, "image/png"))
+		})
+	}
+}
+
+func TestClearGPS(t *testing.T) {
+	for _, bo := range byteOrders {
+		t.Run(bo.name, func(t *testing.T) {
+			// GPS情報がない場合は変更しない
+			exif, _ := buildTIFF(bo.order, [][]testTIFFEntry{{asciiEntry(tiffTagModel, "Camera")}}, -1)
+			original := bytes.Clone(exif)
+			if newTIFF(exif).clearGPS() {
+				t.Fatal("clearGPS reported a change without a GPS IFD")
+			}
+			if !bytes.Equal(exif, original) {
+				t.Fatal("exif without GPS changed")
+			}
+
+			// 範囲外を指すGPSのIFDは無視する
+			exif, _ = buildTIFF(bo.order, [][]testTIFFEntry{{pointerEntry(tiffTagGPSIFD, 1)}, {}}, -1)
+			bo.order.PutUint32(exif[8+2+8:], 1<<20)
+			original = bytes.Clone(exif)
+			if newTIFF(exif).clearGPS() {
+				t.Fatal("clearGPS reported a change for an out-of-range GPS IFD")
+			}
+			if !bytes.Equal(exif, original) {
+				t.Fatal("exif with an out-of-range GPS IFD changed")
+			}
+		})
+	}
+}
+
+func TestExtractImageMetadataOrientation(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, sampleImage()); err != nil {
+		t.Fatal(err)
+	}
+	for _, bo := range byteOrders {
+		t.Run(bo.name, func(t *testing.T) {
+			orientation := make([]byte, 2)
+			bo.order.PutUint16(orientation, 6)
+			exif, _ := buildTIFF(bo.order, [][]testTIFFEntry{{
+				asciiEntry(tiffTagMake, "Canon"),
+				asciiEntry(tiffTagModel, "Canon EOS R5"),
+				{tag: tiffTagOrientation, typ: 3, count: 1, value: orientation},
+				asciiEntry(tiffTagDateTime, "2024:05:06 10:00:00"),
+			}}, -1)
+
+			var input bytes.Buffer
+			input.Write(encoded.Bytes()[:ihdrEnd])
+			input.Write(pngChunk("eXIf", exif))
+			input.Write(encoded.Bytes()[ihdrEnd:])
+
+			// 90度回転する画像は縦横を入れ替え、機種名のメーカー名は重ねない
+			want := ImageMetadata{Width: 16, Height: 24, Camera: "Canon EOS R5", Taken: "2024-05-06T10:00:00"}
+			if meta := extractImageMetadata(input.Bytes(), "image/png"); meta == nil || *meta != want {
+				t.Fatalf("metadata = %+v, want %+v", meta, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"maps"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var (
+	// メタデータインデックスのスナップショット保存先（SEARCH_INDEX_PATH、未設定の場合は保存しない）
+	SearchIndexPath = os.Getenv("SEARCH_INDEX_PATH")
+	// インデックスの再構築間隔（SEARCH_INDEX_REBUILD_INTERVAL、既定6時間）
+	SearchIndexRebuildInterval = envDuration("SEARCH_INDEX_REBUILD_INTERVAL", 6*time.Hour)
+)
+
+// 検索用のオブジェクトメタデータ
+type indexEntry struct {
+	Key          string            `json:"key"`
+	Size         int64             `json:"size"`
+	LastModified time.Time         `json:"lastModified"`
+	ContentType  string            `json:"contentType"`
+	Tags         map[string]string `json:"tags,omitempty"`
+	Image        *ImageMetadata    `json:"image,omitempty"`
+}
+
+var (
+	indexMu       sync.RWMutex
+	metadataIndex = map[string]*indexEntry{}
+	// 再構築中の変更（再構築結果に上書き適用する、nilは削除）
+	pendingIndex map[string]*indexEntry
+)
+
+// 検索対象のキーか判定（"." で始まる領域と .keep は対象外）
+func isIndexable(key string) bool {
+	return !strings.HasPrefix(key, ".") && path.Base(key) != ".keep"
+}
+
+func setIndexEntryLocked(key string, entry *indexEntry) {
+	if entry == nil {
+		delete(metadataIndex, key)
+	} else {
+		metadataIndex[key] = entry
+	}
+	if pendingIndex != nil {
+		pendingIndex[key] = entry
+	}
+}
+
+// オブジェクトをインデックスに追加・更新
+func indexObject(key string, size int64, modified time.Time, contentType string, tags map[string]string, image *ImageMetadata) {
+	if !isIndexable(key) {
+		return
+	}
+	if modified.IsZero() {
+		modified = time.Now()
+	}
+
+	indexMu.Lock()
+	setIndexEntryLocked(key, &indexEntry{
+		Key:          key,
+		Size:         size,
+		LastModified: modified,
+		ContentType:  guessContentType(key, contentType),
+		Tags:         tags,
+		Image:        image,
+	})
+	indexMu.Unlock()
+
+	// 本文の抽出・サムネイルの生成は非同期で行う
+	enqueueFullText(key, false)
+	enqueueThumbnail(key)
+}
+
+// 複製先のエントリを複製元のメタデータから作成
+func indexCopy(srcKey, dstKey string, size int64) {
+	indexMu.RLock()
+	src := metadataIndex[srcKey]
+	indexMu.RUnlock()
+
+	var contentType string
+	var tags map[string]string
+	var image *ImageMetadata
+	if src != nil {
+		contentType, tags, image = src.ContentType, src.Tags, src.Image
+	}
+	indexObject(dstKey, size, time.Now(), contentType, tags, image)
+}
+
+// インデックス済みのオブジェクトのタグを更新
+func setIndexTags(key string, tags map[string]string) {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+
+	current := metadataIndex[key]
+	if current == nil {
+		return
+	}
+	// 検索中の読み取りと競合しないよう複製して置き換える
+	updated := *current
+	updated.Tags = nil
+	if len(tags) > 0 {
+		updated.Tags = maps.Clone(tags)
+	}
+	setIndexEntryLocked(key, &updated)
+}
+
+// インデックスにキーが存在するか確認
+func indexHasKey(key string) bool {
+	indexMu.RLock()
+	defer indexMu.RUnlock()
+	return metadataIndex[key] != nil
+}
+
+// オブジェクトをインデックスから削除
+func unindexObject(key string) {
+	if !isIndexable(key) {
+		return
+	}
+
+	indexMu.Lock()
+	setIndexEntryLocked(key, nil)
+	indexMu.Unlock()
+
+	enqueueFullText(key, false)
+	enqueueThumbnail(key)
+}
+
+// バケット全体を走査してインデックスを再構築（差分更新のずれを補正）
+func RebuildIndex() error {
+	indexMu.Lock()
+	pendingIndex = map[string]*indexEntry{}
+	indexMu.Unlock()
+
+	rebuilt := map[string]*indexEntry{}
+	objectCh := client.ListObjects(context.Background(), bucketName, minio.ListObjectsOptions{
+		Recursive:    true,
+		WithMetadata: true, // コンテンツタイプとタグを取得するため
+	})
+	for object := range objectCh {
+		if object.Err != nil {
+			indexMu.Lock()
+			pendingIndex = nil
+			indexMu.Unlock()
+			return object.Err
+		}
+		if !isIndexable(object.Key) {
+			continue
+		}
+		object = logicalObject(object)
+		var tags map[string]string
+		if len(object.UserTags) > 0 {
+			tags = object.UserTags
+		}
+		rebuilt[object.Key] = &indexEntry{
+			Key:          object.Key,
+			Size:         object.Size,
+			LastModified: object.LastModified,
+			ContentType:  objectContentType(object),
+			Tags:         tags,
+			Image:        imageFromMetadata(listedMetadata(object.UserMetadata)),
+		}
+	}
+
+	// 走査中に行われた変更を反映してから置き換える
+	indexMu.Lock()
+	for key, entry := range pendingIndex {
+		if entry == nil {
+			delete(rebuilt, key)
+		} else {
+			rebuilt[key] = entry
+		}
+	}
+	metadataIndex = rebuilt
+	pendingIndex = nil
+	indexMu.Unlock()
+
+	// 取りこぼした変更を全文検索インデックスにも反映
+	go func() {
+		if err := syncFullText(); err != nil {
+			log.Printf("Full-text sync error: %v", err)
+		}
+		if err := syncThumbnails(); err != nil {
+			log.Printf("Thumbnail sync error: %v", err)
+		}
+	}()
+
+	return saveIndexSnapshot()
+}
+
+// インデックスをファイルに保存
+func saveIndexSnapshot() error {
+	if SearchIndexPath == "" {
+		return nil
+	}
+
+	indexMu.RLock()
+	entries := make([]*indexEntry, 0, len(metadataIndex))
+	for _, entry := range metadataIndex {
+		entries = append(entries, entry)
+	}
+	data, err := json.Marshal(entries)
+	indexMu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	// 書き込み途中で停止しても壊れないよう一時ファイル経由で置き換える
+	tmp := SearchIndexPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, SearchIndexPath)
+}
+
+// 保存済みのインデックスを読み込む（存在しない場合は false）
+func loadIndexSnapshot() (bool, error) {
+	if SearchIndexPath == "" {
+		return false, nil
+	}
+	data, err := os.ReadFile(SearchIndexPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var entries []*indexEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return false, err
+	}
+
+	loaded := make(map[string]*indexEntry, len(entries))
+	for _, entry := range entries {
+		loaded[entry.Key] = entry
+	}
+	indexMu.Lock()
+	metadataIndex = loaded
+	indexMu.Unlock()
+	return true, nil
+}
+
+// インデックスを読み込み、定期的な再構築を開始
+// スナップショットがあればそれを使って即座に検索可能にし、再構築はバックグラウンドで行う
+func StartSearchIndexer() error {
+	loaded, err := loadIndexSnapshot()
+	if err != nil {
+		log.Printf("Search index snapshot ignored: %v", err)
+	}
+	if !loaded {
+		if err := RebuildIndex(); err != nil {
+			return err
+		}
+	}
+
+	go func() {
+		if loaded {
+			if err := RebuildIndex(); err != nil {
+				log.Printf("Search index rebuild error: %v", err)
+			}
+		}
+
+		ticker := time.NewTicker(SearchIndexRebuildInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := RebuildIndex(); err != nil {
+				log.Printf("Search index rebuild error: %v", err)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,350 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// 一覧取得のページング・並び替え・絞り込み条件
+type ListOptions struct {
+	Limit  int    // 1ページの件数（0は全件）
+	Cursor string // 前ページの nextCursor
+	SortBy string // "name" / "size" / "modified" / "type"（既定 name）
+	Order  string // "asc" / "desc"（既定 asc）
+
+	// 以下の絞り込みはファイルにのみ適用（フォルダは常に含まれる）
+	Extensions     []string // 拡張子（ドットなし、大文字小文字を区別しない）
+	ContentTypes   []string // コンテンツタイプ（"image/*" のような前方一致も可）
+	MinSize        int64    // 0は下限なし
+	MaxSize        int64    // 0は上限なし
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+	Tags           map[string]string // タグ（値が "" の場合はキーの存在のみ確認）
+}
+
+// 一覧の1項目（ファイルまたはフォルダ）
+type listEntry struct {
+	Name         string
+	IsFolder     bool
+	Size         int64
+	LastModified time.Time
+	ContentType  string
+	Tags         map[string]string
+	key          string         // オブジェクトキー（フォルダの場合は末尾 "/" 付きプレフィックス）
+	image        *ImageMetadata // 画像のメタデータ（検索結果のみ）
+}
+
+// ページ位置を表すカーソル（base64エンコードしたJSONとしてクライアントに渡す）
+type listCursor struct {
+	SortBy   string    `json:"by"`
+	Order    string    `json:"o"`
+	Key      string    `json:"k,omitempty"` // 名前昇順の場合は StartAfter に使用
+	Name     string    `json:"n,omitempty"`
+	Folder   bool      `json:"f,omitempty"`
+	Size     int64     `json:"s,omitempty"`
+	Modified time.Time `json:"m,omitempty"`
+	Type     string    `json:"t,omitempty"`
+}
+
+func (o *ListOptions) normalize() {
+	switch o.SortBy {
+	case "size", "modified", "type":
+	default:
+		o.SortBy = "name"
+	}
+	if o.Order != "desc" {
+		o.Order = "asc"
+	}
+	if o.Limit < 0 {
+		o.Limit = 0
+	}
+}
+
+// コンテンツタイプ・タグが必要な場合のみ一覧でメタデータを取得
+func (o ListOptions) needsMetadata() bool {
+	return len(o.ContentTypes) > 0 || len(o.Tags) > 0 || o.SortBy == "type"
+}
+
+// ファイルが絞り込み条件に一致するか判定
+func (o ListOptions) matches(entry listEntry) bool {
+	if entry.IsFolder {
+		return true
+	}
+	if len(o.Extensions) > 0 {
+		ext := strings.ToLower(strings.TrimPrefix(path.Ext(entry.Name), "."))
+		found := false
+		for _, want := range o.Extensions {
+			if ext == strings.ToLower(strings.TrimPrefix(want, ".")) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(o.ContentTypes) > 0 {
+		found := false
+		for _, want := range o.ContentTypes {
+			if matchContentType(entry.ContentType, strings.ToLower(want)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if o.MinSize > 0 && entry.Size < o.MinSize {
+		return false
+	}
+	if o.MaxSize > 0 && entry.Size > o.MaxSize {
+		return false
+	}
+	if !o.ModifiedAfter.IsZero() && entry.LastModified.Before(o.ModifiedAfter) {
+		return false
+	}
+	if !o.ModifiedBefore.IsZero() && entry.LastModified.After(o.ModifiedBefore) {
+		return false
+	}
+	for key, want := range o.Tags {
+		value, ok := entry.Tags[key]
+		if !ok || (want != "" && value != want) {
+			return false
+		}
+	}
+	return true
+}
+
+// 種類順の比較キー（フォルダを先頭に、ファイルはコンテンツタイプ順）
+func (e listEntry) typeKey() string {
+	if e.IsFolder {
+		return ""
+	}
+	return e.ContentType
+}
+
+// 並び替え条件に従って比較（同値の場合は名前順）
+func compareEntries(a, b listEntry, opts ListOptions) int {
+	var c int
+	switch opts.SortBy {
+	case "size":
+		c = cmp.Compare(a.Size, b.Size)
+	case "modified":
+		c = a.LastModified.Compare(b.LastModified)
+	case "type":
+		c = strings.Compare(a.typeKey(), b.typeKey())
+	}
+	if c == 0 {
+		c = strings.Compare(a.Name, b.Name)
+	}
+	if opts.Order == "desc" {
+		c = -c
+	}
+	return c
+}
+
+func encodeCursor(cursor listCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string, opts ListOptions) (*listCursor, error) {
+	if value == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor listCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	// 並び順が変わった場合は前ページの続きとして解釈できない
+	if cursor.SortBy != opts.SortBy || cursor.Order != opts.Order {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+func cursorFor(entry listEntry, opts ListOptions) string {
+	return encodeCursor(listCursor{
+		SortBy:   opts.SortBy,
+		Order:    opts.Order,
+		Key:      entry.key,
+		Name:     entry.Name,
+		Folder:   entry.IsFolder,
+		Size:     entry.Size,
+		Modified: entry.LastModified,
+		Type:     entry.typeKey(),
+	})
+}
+
+// 非再帰の一覧結果を1項目に変換（.keepと自身のプレフィックスは除外）
+func toListEntry(prefix string, object minio.ObjectInfo, withMetadata bool) (listEntry, bool) {
+	rel := strings.TrimPrefix(object.Key, prefix)
+	if rel == "" || rel == ".keep" {
+		return listEntry{}, false
+	}
+
+	// サブディレクトリの場合
+	if i := strings.Index(rel, "/"); i >= 0 {
+		name := rel[:i]
+		return listEntry{
+			Name:         name,
+			IsFolder:     true,
+			LastModified: object.LastModified,
+			key:          prefix + name + "/",
+		}, true
+	}
+
+	entry := listEntry{
+		Name:         rel,
+		Size:         object.Size,
+		LastModified: object.LastModified,
+		ContentType:  object.ContentType,
+		key:          object.Key,
+	}
+	if withMetadata {
+		entry.ContentType = objectContentType(object)
+		entry.Tags = object.UserTags
+	}
+	return entry, true
+}
+
+// 指定階層の一覧を条件に従って取得し、1ページ分と次ページのカーソルを返す
+// 名前昇順の場合は StartAfter で続きから読み出し、1ページ分を読んだ時点で打ち切る。
+// それ以外の並び順は階層全体を読み込んで並び替える必要がある。
+func listLevel(prefix string, opts ListOptions, withFolderStats bool) ([]listEntry, string, error) {
+	opts.normalize()
+	cursor, err := decodeCursor(opts.Cursor, opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	streaming := opts.SortBy == "name" && opts.Order == "asc" && opts.Limit > 0
+	withMetadata := opts.needsMetadata()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	listOpts := minio.ListObjectsOptions{
+		Prefix:       prefix,
+		Recursive:    false, // 指定階層のみ
+		WithMetadata: true,  // 圧縮・暗号化されたファイルのサイズを取得するため
+	}
+	if streaming && cursor != nil {
+		listOpts.StartAfter = cursor.Key
+		if strings.HasSuffix(cursor.Key, "/") {
+			// フォルダの場合は配下のキーも読み飛ばす
+			listOpts.StartAfter += string(utf8.MaxRune)
+		}
+	}
+
+	entries := []listEntry{}
+	seenFolders := map[string]bool{}
+	hasMore := false
+
+	for object := range client.ListObjects(ctx, bucketName, listOpts) {
+		if object.Err != nil {
+			return nil, "", object.Err
+		}
+
+		entry, ok := toListEntry(prefix, logicalObject(object), withMetadata)
+		if !ok {
+			continue
+		}
+		if entry.IsFolder {
+			if seenFolders[entry.Name] {
+				continue
+			}
+			seenFolders[entry.Name] = true
+		}
+		if streaming && cursor != nil && entry.key <= cursor.Key {
+			continue
+		}
+		if !opts.matches(entry) {
+			continue
+		}
+		if streaming && len(entries) == opts.Limit {
+			hasMore = true
+			break
+		}
+		entries = append(entries, entry)
+	}
+
+	// フォルダのサイズ・最終更新日時は配下の集計値を使用
+	// 先に親階層を集計しておくと、サブフォルダ分もまとめてキャッシュされる
+	if withFolderStats {
+		if _, err := GetFolderStats(prefix); err != nil {
+			return nil, "", err
+		}
+		for i := range entries {
+			if !entries[i].IsFolder {
+				continue
+			}
+			stats, err := GetFolderStats(entries[i].key)
+			if err != nil {
+				return nil, "", err
+			}
+			entries[i].Size = stats.Size
+			if stats.LastModified.After(entries[i].LastModified) {
+				entries[i].LastModified = stats.LastModified
+			}
+		}
+	}
+
+	if streaming {
+		next := ""
+		if hasMore {
+			next = cursorFor(entries[len(entries)-1], opts)
+		}
+		return entries, next, nil
+	}
+
+	page, next := paginate(entries, opts, cursor)
+	return page, next, nil
+}
+
+// 並び替えた上でカーソル位置以降の1ページ分を切り出す
+func paginate(entries []listEntry, opts ListOptions, cursor *listCursor) ([]listEntry, string) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return compareEntries(entries[i], entries[j], opts) < 0
+	})
+
+	// カーソル位置より後ろの項目から返す
+	start := 0
+	if cursor != nil {
+		last := listEntry{
+			Name:         cursor.Name,
+			IsFolder:     cursor.Folder,
+			Size:         cursor.Size,
+			LastModified: cursor.Modified,
+			ContentType:  cursor.Type,
+		}
+		start = sort.Search(len(entries), func(i int) bool {
+			return compareEntries(last, entries[i], opts) < 0
+		})
+	}
+	page := entries[start:]
+
+	next := ""
+	if opts.Limit > 0 && len(page) > opts.Limit {
+		page = page[:opts.Limit]
+		next = cursorFor(page[len(page)-1], opts)
+	}
+	return page, next
+}
@@ -20,24 +20,34 @@ var (
 )
 
 type FileInfo struct {
-	Name         string    `json:"name"`
-	Size         int64     `json:"size"`
-	LastModified time.Time `json:"lastModified"`
-	ContentType  string    `json:"contentType"`
+	Name         string     `json:"name"`
+	Size         int64      `json:"size"`
+	LastModified time.Time  `json:"lastModified"`
+	ContentType  string     `json:"contentType"`
+	Checksums    *Checksums `json:"checksums,omitempty"`
 }
 
 type FolderInfo struct {
 	Name         string    `json:"name"`
-	Type         string    `json:"type"` // "folder"
-	ItemCount    int       `json:"itemCount"`
+	Type         string    `json:"type"`      // "folder"
+	ItemCount    int       `json:"itemCount"` // サブフォルダを含む配下のファイル数
+	Size         int64     `json:"size"`      // サブフォルダを含む合計サイズ
 	LastModified time.Time `json:"lastModified"`
 }
 
 func InitMinIO() error {
+	// 暗号化設定が不正な場合は起動しない
+	if err := initEncryption(); err != nil {
+		return err
+	}
+	if err := initEnvelope(); err != nil {
+		return err
+	}
+
 	var err error
 	client, err = minio.New("localhost:9000", &minio.Options{
 		Creds:  credentials.NewStaticV4("minioadmin", "789632145", ""),
-		Secure: false,
+		Secure: MinIOSecure,
 	})
 	if err != nil {
 		return err
@@ -54,6 +64,16 @@ func InitMinIO() error {
 		}
 	}
 
+	// お気に入り・アクティビティ等の保存先
+	if err := ensureUserDataBucket(); err != nil {
+		return err
+	}
+
+	// 設定されている場合はバージョニングを有効化
+	if err := enableVersioning(); err != nil {
+		return err
+	}
+
 	log.Println("Connected to MinIO")
 	return nil
 }
@@ -63,13 +83,15 @@ func ListFiles() ([]FileInfo, error) {
 
 	ctx := context.Background()
 	objectCh := client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
-		Recursive: true,
+		Recursive:    true,
+		WithMetadata: true, // 圧縮・暗号化されたファイルのサイズを取得するため
 	})
 
 	for object := range objectCh {
 		if object.Err != nil {
 			return nil, object.Err
 		}
+		object = logicalObject(object)
 
 		files = append(files, FileInfo{
 			Name:         object.Key,
@@ -83,18 +105,20 @@ func ListFiles() ([]FileInfo, error) {
 }
 
 // フォルダ構造付きでファイル一覧を取得
-func ListFilesWithFolders() (map[string]interface{}, error) {
+func ListFilesWithFolders() (*TreeNode, error) {
 	var allFiles []FileInfo
 
 	ctx := context.Background()
 	objectCh := client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
-		Recursive: true,
+		Recursive:    true,
+		WithMetadata: true, // 圧縮・暗号化されたファイルのサイズを取得するため
 	})
 
 	for object := range objectCh {
 		if object.Err != nil {
 			return nil, object.Err
 		}
+		object = logicalObject(object)
 
 		allFiles = append(allFiles, FileInfo{
 			Name:         object.Key,
@@ -108,219 +132,279 @@ func ListFilesWithFolders() (map[string]interface{}, error) {
 	return buildFolderStructure(allFiles), nil
 }
 
-// ファイルリストからフォルダ構造を構築
-func buildFolderStructure(files []FileInfo) map[string]interface{} {
-	root := make(map[string]interface{})
+// ファイルリストからフォルダ構造を構築（フォルダのサイズ・更新日時は配下の集計）
+func buildFolderStructure(files []FileInfo) *TreeNode {
+	root := &TreeNode{Type: "folder"}
 
 	for _, file := range files {
 		parts := strings.Split(file.Name, "/")
 		current := root
 
 		// フォルダ部分を処理
+		ancestors := []*TreeNode{root}
 		for _, part := range parts[:len(parts)-1] {
-			if current[part] == nil {
-				current[part] = make(map[string]interface{})
-			}
-			if folder, ok := current[part].(map[string]interface{}); ok {
-				current = folder
+			current = current.child(part, "folder")
+			ancestors = append(ancestors, current)
+		}
+		for _, folder := range ancestors {
+			folder.Size += file.Size
+			if file.LastModified.After(folder.LastModified) {
+				folder.LastModified = file.LastModified
 			}
 		}
 
-		// ファイル部分を処理
+		// ファイル部分を処理（.keepはフォルダの存在を示すのみ）
 		fileName := parts[len(parts)-1]
-		current[fileName] = file
+		if fileName == ".keep" {
+			continue
+		}
+		node := current.child(fileName, "file")
+		node.Size = file.Size
+		node.LastModified = file.LastModified
+		node.ContentType = file.ContentType
 	}
 
 	return root
 }
 
 // ユーザー別階層構造でファイル/フォルダ一覧を取得（詳細情報付き）
-func ListUserFiles(userID, path string) (map[string]interface{}, error) {
-	ctx := context.Background()
-
+func ListUserFiles(userID, path string, opts ListOptions) (*Listing, error) {
 	// プレフィックスを構築
 	prefix := userID + "/"
 	if path != "" {
 		prefix += strings.Trim(path, "/") + "/"
 	}
 
-	objectCh := client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
-		Prefix:    prefix,
-		Recursive: false, // 指定階層のみ
-	})
+	entries, nextCursor, err := listLevel(prefix, opts, false)
+	if err != nil {
+		return nil, err
+	}
 
 	files := []FileInfo{}
-	folders := map[string]bool{}
-
-	for object := range objectCh {
-		if object.Err != nil {
-			return nil, object.Err
-		}
+	folders := []string{}
 
-		// プレフィックスを除去して相対パスを取得
-		relativePath := strings.TrimPrefix(object.Key, prefix)
-
-		// .keepファイルは除外（フォルダ作成用ダミー）
-		if strings.HasSuffix(relativePath, ".keep") {
-			folderName := strings.TrimSuffix(relativePath, "/.keep")
-			if folderName != "" {
-				folders[folderName] = true
-			}
+	for _, entry := range entries {
+		if entry.IsFolder {
+			folders = append(folders, entry.Name)
 			continue
 		}
-
-		// サブディレクトリの場合
-		if strings.Contains(relativePath, "/") {
-			folderName := strings.Split(relativePath, "/")[0]
-			folders[folderName] = true
-		} else if relativePath != "" {
-			// ファイルの場合 - 詳細情報を含める
-			fileInfo := FileInfo{
-				Name:         relativePath,
-				Size:         object.Size,
-				LastModified: object.LastModified,
-				ContentType:  object.ContentType,
-			}
-			files = append(files, fileInfo)
-		}
+		// ファイルの場合 - 詳細情報を含める
+		files = append(files, FileInfo{
+			Name:         entry.Name,
+			Size:         entry.Size,
+			LastModified: entry.LastModified,
+			ContentType:  entry.ContentType,
+		})
 	}
 
 	// 結果をまとめる
-	result := map[string]interface{}{
-		"path":    path,
-		"userID":  userID,
-		"files":   files,
-		"folders": getFolderList(folders),
-	}
-
-	return result, nil
+	return &Listing{
+		SchemaVersion: SchemaVersion,
+		Path:          path,
+		UserID:        userID,
+		Files:         files,
+		Folders:       folders,
+		NextCursor:    nextCursor,
+	}, nil
 }
 
 // ユーザー別階層構造でファイル/フォルダ一覧を取得（より詳細な情報付き）
-func ListUserFilesWithDetails(userID, path string) (map[string]interface{}, error) {
-	ctx := context.Background()
-
+func ListUserFilesWithDetails(userID, path string, opts ListOptions) (*DetailedListing, error) {
 	// プレフィックスを構築
 	prefix := userID + "/"
 	if path != "" {
 		prefix += strings.Trim(path, "/") + "/"
 	}
 
-	objectCh := client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
-		Prefix:    prefix,
-		Recursive: false, // 指定階層のみ
-	})
+	entries, nextCursor, err := listLevel(prefix, opts, true)
+	if err != nil {
+		return nil, err
+	}
 
 	files := []FileInfo{}
-	folderMap := map[string]*FolderInfo{}
+	folders := []FolderInfo{}
 	var totalSize int64
 	var totalFiles int
 	var latestModified time.Time
 
-	for object := range objectCh {
-		if object.Err != nil {
-			return nil, object.Err
-		}
-
-		// プレフィックスを除去して相対パスを取得
-		relativePath := strings.TrimPrefix(object.Key, prefix)
-
-		// .keepファイルは除外（フォルダ作成用ダミー）
-		if strings.HasSuffix(relativePath, ".keep") {
-			folderName := strings.TrimSuffix(relativePath, "/.keep")
-			if folderName != "" {
-				if folderMap[folderName] == nil {
-					folderMap[folderName] = &FolderInfo{
-						Name:         folderName,
-						Type:         "folder",
-						ItemCount:    0,
-						LastModified: object.LastModified,
-					}
-				}
+	for _, entry := range entries {
+		if entry.IsFolder {
+			stats, err := GetFolderStats(entry.key)
+			if err != nil {
+				return nil, err
 			}
+			folders = append(folders, FolderInfo{
+				Name:         entry.Name,
+				Type:         "folder",
+				ItemCount:    stats.FileCount,
+				Size:         stats.Size,
+				LastModified: entry.LastModified,
+			})
 			continue
 		}
 
-		// サブディレクトリの場合
-		if strings.Contains(relativePath, "/") {
-			folderName := strings.Split(relativePath, "/")[0]
-			if folderMap[folderName] == nil {
-				folderMap[folderName] = &FolderInfo{
-					Name:         folderName,
-					Type:         "folder",
-					ItemCount:    0,
-					LastModified: object.LastModified,
-				}
-			}
-			folderMap[folderName].ItemCount++
-			if object.LastModified.After(folderMap[folderName].LastModified) {
-				folderMap[folderName].LastModified = object.LastModified
-			}
-		} else if relativePath != "" {
-			// ファイルの場合 - 詳細情報を含める
-			fileInfo := FileInfo{
-				Name:         relativePath,
-				Size:         object.Size,
-				LastModified: object.LastModified,
-				ContentType:  object.ContentType,
-			}
-			files = append(files, fileInfo)
+		// ファイルの場合 - 詳細情報を含める
+		files = append(files, FileInfo{
+			Name:         entry.Name,
+			Size:         entry.Size,
+			LastModified: entry.LastModified,
+			ContentType:  entry.ContentType,
+		})
 
-			// 統計情報を更新
-			totalSize += object.Size
-			totalFiles++
-			if object.LastModified.After(latestModified) {
-				latestModified = object.LastModified
-			}
+		// 統計情報を更新
+		totalSize += entry.Size
+		totalFiles++
+		if entry.LastModified.After(latestModified) {
+			latestModified = entry.LastModified
 		}
 	}
 
-	// フォルダリストを作成
-	folders := make([]FolderInfo, 0, len(folderMap))
-	for _, folder := range folderMap {
-		folders = append(folders, *folder)
+	// サブフォルダを含む集計（キャッシュ済みでなければ配下を一度だけ走査）
+	recursive, err := GetFolderStats(prefix)
+	if err != nil {
+		return nil, err
 	}
 
-	// 結果をまとめる
-	result := map[string]interface{}{
-		"path":    path,
-		"userID":  userID,
-		"files":   files,
-		"folders": folders,
-		"statistics": map[string]interface{}{
-			"totalFiles":     totalFiles,
-			"totalFolders":   len(folders),
-			"totalSize":      totalSize,
-			"totalSizeHuman": formatSizeBytes(totalSize),
-			"latestModified": latestModified,
+	// 結果をまとめる（total* はサブフォルダを含む、direct* は返却したファイルのみ）
+	return &DetailedListing{
+		SchemaVersion: SchemaVersion,
+		Path:          path,
+		UserID:        userID,
+		Files:         files,
+		Folders:       folders,
+		NextCursor:    nextCursor,
+		Statistics: Statistics{
+			TotalFiles:     recursive.FileCount,
+			TotalFolders:   len(folders),
+			TotalSize:      recursive.Size,
+			TotalSizeHuman: formatSizeBytes(recursive.Size),
+			LatestModified: recursive.LastModified,
+			DirectFiles:    totalFiles,
+			DirectSize:     totalSize,
+			DirectModified: latestModified,
 		},
-	}
-
-	return result, nil
-}
-
-// フォルダマップからスライスに変換
-func getFolderList(folders map[string]bool) []string {
-	var result []string
-	for folder := range folders {
-		result = append(result, folder)
-	}
-	return result
+	}, nil
 }
 
 // DeleteFile ファイルを削除
+// バージョニング有効時は過去のバージョンも削除し、容量を解放する
 func DeleteFile(filename string) error {
-	err := client.RemoveObject(context.Background(), bucketName, filename, minio.RemoveObjectOptions{})
+	return deleteFile(filename, VersioningEnabled)
+}
+
+// ファイルを削除（allVersions=false の場合、バージョニング有効時は削除マーカーを置き、過去のバージョンとして残す）
+func deleteFile(filename string, allVersions bool) error {
+	ctx := context.Background()
+
+	// 使用量の差分更新のため削除前のサイズを取得
+	stat, statErr := statObject(ctx, filename, minio.StatObjectOptions{})
+
+	var err error
+	if allVersions {
+		err = removeAllVersions(ctx, filename)
+	} else {
+		err = client.RemoveObject(ctx, bucketName, filename, minio.RemoveObjectOptions{})
+	}
 	if err != nil {
 		return err
 	}
+	releaseBlobRef(filename)
+	if statErr == nil {
+		recordChange(filename, -stat.Size, -1)
+		if VersioningEnabled && !allVersions {
+			trackVersions(filename, stat.Size)
+		}
+	}
+	unindexObject(filename)
 	modTime = time.Now()
 	return nil
 }
 
 func SaveFile(filename string, data io.Reader, size int64) error {
-	_, err := client.PutObject(context.Background(), bucketName, filename, data, size, minio.PutObjectOptions{})
+	return SaveFileWithOptions(filename, data, size, UploadOptions{})
+}
+
+// メタデータ・タグ付きでファイルを保存
+func SaveFileWithOptions(filename string, data io.Reader, size int64, opts UploadOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	// 画像はメタデータを抽出し、指定された場合は位置情報を削除する（内容が変わるためサイズも更新）
+	if seeker, ok := data.(io.ReadSeeker); ok {
+		var err error
+		if data, size, opts, err = prepareImage(seeker, size, opts); err != nil {
+			return err
+		}
+	}
+
+	ctx := context.Background()
+
+	// 上書きの場合は既存オブジェクトとの差分のみ使用量に加算
+	var oldSize, oldObjects int64
+	if stat, err := statObject(ctx, filename, minio.StatObjectOptions{}); err == nil {
+		oldSize, oldObjects = stat.Size, 1
+	}
+	// バージョニング有効時は上書き前の内容が過去のバージョンとして残るため、差し引かない
+	var replacedSize int64
+	if !VersioningEnabled {
+		replacedSize = oldSize
+	}
+	if size >= 0 {
+		reservation, err := reserveQuota(filename, size-replacedSize, 1-oldObjects)
+		if err != nil {
+			return err
+		}
+		defer reservation.release()
+	}
+
+	// 読み直せるデータは保存前にチェックサムを検証し、メタデータとして保存する
+	var info minio.UploadInfo
+	var err error
+	if seeker, ok := data.(io.ReadSeeker); ok {
+		var sums Checksums
+		var length int64
+		if sums, length, err = computeChecksums(seeker); err != nil {
+			return err
+		}
+		if err := sums.verify(opts.Checksums); err != nil {
+			return err
+		}
+		if isEnvelopePath(filename) {
+			info, err = putEnvelope(ctx, filename, seeker, length, sums, opts)
+			if err == nil {
+				releaseBlobRef(filename)
+			}
+		} else if DedupEnabled && length >= max(DedupMinSize, 1) {
+			info, err = putDeduplicated(ctx, filename, seeker, length, sums, opts)
+		} else if isCompressible(opts.ContentType, length) {
+			info, err = putCompressed(ctx, filename, seeker, length, sums, opts)
+			if err == nil {
+				releaseBlobRef(filename)
+			}
+		} else {
+			info, err = client.PutObject(ctx, bucketName, filename, seeker, size, minio.PutObjectOptions{
+				UserMetadata:         sums.withMetadata(opts.Metadata),
+				UserTags:             opts.Tags,
+				ContentType:          opts.ContentType,
+				ServerSideEncryption: writeSSE(filename),
+			})
+			if err == nil {
+				releaseBlobRef(filename)
+			}
+		}
+	} else {
+		info, err = putStaged(ctx, filename, data, size, opts)
+		if err == nil {
+			releaseBlobRef(filename)
+		}
+	}
 	if err == nil {
+		recordChange(filename, info.Size-oldSize, 1-oldObjects)
+		if VersioningEnabled && oldObjects > 0 {
+			trackVersions(filename, oldSize)
+		}
+		indexObject(filename, info.Size, info.LastModified, opts.ContentType, opts.Tags, imageFromMetadata(opts.Metadata))
 		modTime = time.Now()
 	}
 	return err
@@ -356,17 +440,41 @@ func normalizePath(path, filename string) string {
 }
 
 func GetFile(filename string) (io.ReadSeekCloser, error) {
-	obj, err := client.GetObject(context.Background(), bucketName, filename, minio.GetObjectOptions{})
+	obj, _, err := OpenFile(filename)
+	return obj, err
+}
+
+// ファイルを開き、内容と詳細情報を同時に取得（Statの二重呼び出しを避ける）
+func OpenFile(filename string) (io.ReadSeekCloser, *FileInfo, error) {
+	return openObject(filename, minio.GetObjectOptions{})
+}
+
+func openObject(filename string, opts minio.GetObjectOptions) (io.ReadSeekCloser, *FileInfo, error) {
+	obj, stat, err := getObject(context.Background(), filename, opts)
 	if err != nil {
-		return nil, err
+		return nil, nil, errors.New("file not found")
 	}
-	stat, err := obj.Stat()
+	modTime = stat.LastModified
+
+	// 暗号化・圧縮されたファイルは復号・展開しながら返す
+	reader, stat, err := decryptObject(obj, stat)
 	if err != nil {
-		obj.Close() // リソースリークを防ぐ
-		return nil, errors.New("file not found")
+		obj.Close()
+		return nil, nil, err
 	}
-	modTime = stat.LastModified
-	return obj, nil
+	if reader, stat, err = decompressObject(reader, stat); err != nil {
+		obj.Close()
+		return nil, nil, err
+	}
+
+	info := &FileInfo{
+		Name:         stat.Key,
+		Size:         stat.Size,
+		LastModified: stat.LastModified,
+		ContentType:  stat.ContentType,
+		Checksums:    checksumsFromMetadata(stat.UserMetadata),
+	}
+	return reader, info, nil
 }
 
 func LastModified() time.Time {
@@ -378,7 +486,7 @@ func GetFileInfo(filename string) (*FileInfo, error) {
 	ctx := context.Background()
 
 	// オブジェクトの統計情報を取得
-	objInfo, err := client.StatObject(ctx, bucketName, filename, minio.StatObjectOptions{})
+	objInfo, err := statObject(ctx, filename, minio.StatObjectOptions{})
 	if err != nil {
 		return nil, err
 	}
@@ -394,6 +502,7 @@ func GetFileInfo(filename string) (*FileInfo, error) {
 		Size:         objInfo.Size,
 		LastModified: objInfo.LastModified,
 		ContentType:  contentType,
+		Checksums:    checksumsFromMetadata(objInfo.UserMetadata),
 	}
 
 	return fileInfo, nil
@@ -403,7 +512,7 @@ func GetFileInfo(filename string) (*FileInfo, error) {
 func GetFileSize(filename string) (int64, error) {
 	ctx := context.Background()
 
-	objInfo, err := client.StatObject(ctx, bucketName, filename, minio.StatObjectOptions{})
+	objInfo, err := statObject(ctx, filename, minio.StatObjectOptions{})
 	if err != nil {
 		return 0, err
 	}
@@ -412,28 +521,37 @@ func GetFileSize(filename string) (int64, error) {
 }
 
 // ファイルメタデータを取得（詳細な情報）
-func GetFileMetadata(filename string) (map[string]interface{}, error) {
+func GetFileMetadata(filename string) (*ObjectMetadata, error) {
 	ctx := context.Background()
 
-	objInfo, err := client.StatObject(ctx, bucketName, filename, minio.StatObjectOptions{})
+	objInfo, err := statObject(ctx, filename, minio.StatObjectOptions{})
 	if err != nil {
 		return nil, err
 	}
 
-	metadata := map[string]interface{}{
-		"name":           objInfo.Key,
-		"size":           objInfo.Size,
-		"lastModified":   objInfo.LastModified,
-		"contentType":    objInfo.ContentType,
-		"etag":           objInfo.ETag,
-		"versionId":      objInfo.VersionID,
-		"isDeleteMarker": objInfo.IsDeleteMarker,
-		"metadata":       objInfo.UserMetadata,
-		"expires":        objInfo.Expires,
-		"storageClass":   objInfo.StorageClass,
+	objectTags := map[string]string{}
+	if objInfo.UserTagCount > 0 {
+		if objectTags, err = GetTags(filename); err != nil {
+			return nil, err
+		}
 	}
 
-	return metadata, nil
+	return &ObjectMetadata{
+		SchemaVersion:  SchemaVersion,
+		Name:           objInfo.Key,
+		Size:           objInfo.Size,
+		LastModified:   objInfo.LastModified,
+		ContentType:    objInfo.ContentType,
+		ETag:           objInfo.ETag,
+		VersionID:      objInfo.VersionID,
+		IsDeleteMarker: objInfo.IsDeleteMarker,
+		Metadata:       publicMetadata(objInfo.UserMetadata),
+		Checksums:      checksumsFromMetadata(objInfo.UserMetadata),
+		Image:          imageFromMetadata(objInfo.UserMetadata),
+		Expires:        objInfo.Expires,
+		StorageClass:   objInfo.StorageClass,
+		Tags:           objectTags,
+	}, nil
 }
 
 // ファイルサイズを人間が読みやすい形式にフォーマット
@@ -0,0 +1,312 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// 単一のCopyObjectで複製できる最大サイズ（5GiB）
+const maxCopyObjectSize = 5 << 30
+
+var (
+	ErrAlreadyExists = errors.New("destination already exists")
+	ErrNotFound      = errors.New("source not found")
+	ErrMoveIntoSelf  = errors.New("cannot move a folder into itself")
+	ErrCopyIntoSelf  = errors.New("cannot copy a folder into itself")
+)
+
+// オブジェクト単位のエラー情報
+type ObjectError struct {
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+// フォルダ移動の進捗情報
+type MoveProgress struct {
+	Total     int    `json:"total"`
+	Processed int    `json:"processed"`
+	Moved     int    `json:"moved"`
+	Failed    int    `json:"failed"`
+	Current   string `json:"current"`
+}
+
+// フォルダ移動の結果（一部失敗を含む）
+type MoveResult struct {
+	Source      string        `json:"source"`
+	Destination string        `json:"destination"`
+	Total       int           `json:"total"`
+	Moved       int           `json:"moved"`
+	Resumed     int           `json:"resumed"` // 前回中断時に複製済みだったオブジェクト数
+	TotalSize   int64         `json:"totalSize"`
+	Failed      int           `json:"failed"`
+	Errors      []ObjectError `json:"errors"`
+	Completed   bool          `json:"completed"`
+}
+
+// ファイルが存在するか確認
+func FileExists(key string) (bool, error) {
+	_, err := statObject(context.Background(), key, minio.StatObjectOptions{})
+	if err == nil {
+		return true, nil
+	}
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return false, nil
+	}
+	return false, err
+}
+
+// プレフィックス配下にオブジェクトが存在するか確認
+func PrefixExists(prefix string) (bool, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	objectCh := client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+		MaxKeys:   1,
+	})
+	for object := range objectCh {
+		if object.Err != nil {
+			return false, object.Err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// プレフィックス配下の全オブジェクトを再帰的に取得
+func listAllObjects(prefix string) ([]minio.ObjectInfo, error) {
+	var objects []minio.ObjectInfo
+
+	objectCh := client.ListObjects(context.Background(), bucketName, minio.ListObjectsOptions{
+		Prefix:       prefix,
+		Recursive:    true,
+		WithMetadata: true, // 圧縮・暗号化されたファイルのサイズを取得するため
+	})
+	for object := range objectCh {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		objects = append(objects, logicalObject(object))
+	}
+	return objects, nil
+}
+
+// サーバーサイドでオブジェクトを複製（データは本プロセスを経由しない）
+// CopyObjectは5GiBまでのため、それを超える場合はComposeObjectでパート単位に複製する
+func copyObject(srcKey, dstKey string, size int64) error {
+	// 暗号化されていないファイルを暗号化フォルダへ複製する場合は、本サーバーで暗号化して保存する
+	if isEnvelopePath(dstKey) {
+		stat, _, err := statPhysical(context.Background(), srcKey, minio.StatObjectOptions{})
+		if err != nil {
+			return err
+		}
+		if _, sealed := envelopeFromMetadata(stat.UserMetadata); !sealed {
+			return copyIntoEnvelope(srcKey, dstKey, size)
+		}
+	}
+
+	// 複製先の所有者の鍵で暗号化し直す
+	srcSSE, err := sourceSSE(context.Background(), srcKey, "")
+	if err != nil {
+		return err
+	}
+	dst := minio.CopyDestOptions{Bucket: bucketName, Object: dstKey, Encryption: writeSSE(dstKey)}
+	src := minio.CopySrcOptions{Bucket: bucketName, Object: srcKey, Encryption: srcSSE}
+
+	// ポインタの実体は空のため、サイズによらずCopyObjectで複製できる
+	if size > maxCopyObjectSize && !isPointerKey(srcKey) {
+		_, err = client.ComposeObject(context.Background(), dst, src)
+	} else {
+		_, err = client.CopyObject(context.Background(), dst, src)
+	}
+	if err == nil {
+		copyBlobRef(srcKey, dstKey)
+		recordChange(dstKey, size, 1)
+		indexCopy(srcKey, dstKey, size)
+	}
+	return err
+}
+
+// オブジェクトを削除し使用量に反映（バージョニング有効時は過去のバージョンとして残る）
+func removeObject(object minio.ObjectInfo) error {
+	err := client.RemoveObject(context.Background(), bucketName, object.Key, minio.RemoveObjectOptions{})
+	if err == nil {
+		releaseBlobRef(object.Key)
+		recordChange(object.Key, -object.Size, -1)
+		if VersioningEnabled {
+			trackVersions(object.Key, object.Size)
+		}
+		unindexObject(object.Key)
+	}
+	return err
+}
+
+// ファイルを移動（CopyObject + 元ファイル削除）
+func MoveFile(srcKey, dstKey string, overwrite bool) error {
+	if srcKey == dstKey {
+		return nil
+	}
+
+	if err := copyFile(srcKey, dstKey, overwrite, true); err != nil {
+		return err
+	}
+	// 移動元の履歴は保持期間まで残す（完全な削除ではないため）
+	return deleteFile(srcKey, false)
+}
+
+// ファイルをサーバーサイドで複製
+func CopyFile(srcKey, dstKey string, overwrite bool) error {
+	return copyFile(srcKey, dstKey, overwrite, false)
+}
+
+// 移動（move=true）の場合、同一所有者内であれば使用量が変わらないためクォータ確認を省略
+func copyFile(srcKey, dstKey string, overwrite, move bool) error {
+	if srcKey == dstKey {
+		return ErrAlreadyExists
+	}
+
+	srcInfo, err := statObject(context.Background(), srcKey, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	if !overwrite {
+		exists, err := FileExists(dstKey)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrAlreadyExists
+		}
+	}
+	if !move || quotaOwner(srcKey) != quotaOwner(dstKey) {
+		reservation, err := reserveQuota(dstKey, srcInfo.Size, 1)
+		if err != nil {
+			return err
+		}
+		defer reservation.release()
+	}
+
+	if err := copyObject(srcKey, dstKey, srcInfo.Size); err != nil {
+		return err
+	}
+	modTime = time.Now()
+	return nil
+}
+
+// フォルダを再帰的に移動（.keepを含むプレフィックス配下の全オブジェクト）
+// 中断後に同じ移動を再実行すると、移動済みのオブジェクトは元から消えているため残りのみ処理される。
+// 複製済みで削除前に中断したオブジェクトはETagが一致すれば複製を省略する。
+func MoveFolder(srcPrefix, dstPrefix string, overwrite bool, progress func(MoveProgress)) (*MoveResult, error) {
+	srcPrefix = strings.TrimSuffix(srcPrefix, "/") + "/"
+	dstPrefix = strings.TrimSuffix(dstPrefix, "/") + "/"
+
+	if srcPrefix == dstPrefix {
+		return nil, ErrAlreadyExists
+	}
+	if strings.HasPrefix(dstPrefix, srcPrefix) {
+		return nil, ErrMoveIntoSelf
+	}
+
+	objects, err := listAllObjects(srcPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, ErrNotFound
+	}
+
+	result := &MoveResult{
+		Source:      srcPrefix,
+		Destination: dstPrefix,
+		Total:       len(objects),
+		Errors:      []ObjectError{},
+	}
+	state := MoveProgress{Total: len(objects)}
+
+	for _, object := range objects {
+		dstKey := dstPrefix + strings.TrimPrefix(object.Key, srcPrefix)
+		state.Current = object.Key
+
+		resumed, err := moveObject(object, dstKey, overwrite)
+		if err != nil {
+			result.Failed++
+			result.Errors = append(result.Errors, ObjectError{Key: object.Key, Error: err.Error()})
+		} else {
+			result.Moved++
+			result.TotalSize += object.Size
+			if resumed {
+				result.Resumed++
+			}
+		}
+
+		state.Processed++
+		state.Moved = result.Moved
+		state.Failed = result.Failed
+		if progress != nil {
+			progress(state)
+		}
+	}
+
+	result.Completed = result.Failed == 0
+	modTime = time.Now()
+	return result, nil
+}
+
+// フォルダ移動の1オブジェクト分を処理（複製済みならtrueを返す）
+func moveObject(object minio.ObjectInfo, dstKey string, overwrite bool) (bool, error) {
+	ctx := context.Background()
+
+	dstInfo, err := statObject(ctx, dstKey, minio.StatObjectOptions{})
+	switch {
+	case err == nil && sameContent(ctx, object, dstInfo):
+		// 前回の移動で複製済み - 元オブジェクトの削除のみ行う
+		return true, removeObject(object)
+	case err == nil && !overwrite:
+		return false, ErrAlreadyExists
+	case err != nil && minio.ToErrorResponse(err).Code != "NoSuchKey":
+		return false, err
+	}
+
+	if err := copyObject(object.Key, dstKey, object.Size); err != nil {
+		return false, err
+	}
+	return false, removeObject(object)
+}
+
+// 複製先が元オブジェクトと同じ内容か
+// 重複排除のポインタは全て空のオブジェクトでETagが一致するため、ポインタを含む場合は内容のSHA-256で比較する
+// 暗号化フォルダへ暗号化し直して複製した場合もETagが異なるため、同様に比較する
+func sameContent(ctx context.Context, object, dstInfo minio.ObjectInfo) bool {
+	if dstInfo.Size != object.Size {
+		return false
+	}
+	srcInfo, _, err := statPhysical(ctx, object.Key, minio.StatObjectOptions{})
+	if err != nil {
+		return false
+	}
+	srcHash, srcPointer := contentHash(srcInfo.UserMetadata)
+	dstHash, dstPointer := contentHash(dstInfo.UserMetadata)
+	_, srcSealed := envelopeFromMetadata(srcInfo.UserMetadata)
+	_, dstSealed := envelopeFromMetadata(dstInfo.UserMetadata)
+	if srcPointer || dstPointer || srcSealed != dstSealed {
+		return srcHash != "" && srcHash == dstHash
+	}
+	return srcInfo.ETag == dstInfo.ETag
+}
+
+// 内容のSHA-256（ポインタの場合は参照先blobのハッシュ、記録されていなければ空）
+func contentHash(metadata map[string]string) (hash string, pointer bool) {
+	if hash, _, ok := pointerTarget(metadata); ok {
+		return hash, true
+	}
+	return metadata[checksumSHA256Key], false
+}
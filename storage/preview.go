@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"io"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var (
+	// プレビューで読み取る先頭部分の上限（PREVIEW_MAX_BYTES、既定64KiB）
+	PreviewMaxBytes = envInt64("PREVIEW_MAX_BYTES", 64<<10)
+	// CSVプレビューで返す最大行数（PREVIEW_MAX_ROWS、既定100）
+	PreviewMaxRows = envInt64("PREVIEW_MAX_ROWS", 100)
+)
+
+// ファイルの先頭 limit バイトを読み取る（FileInfo.Size はファイル全体のサイズ）
+// 通常のファイルは範囲指定のGETで必要な分だけ取得し、圧縮・暗号化されたファイルは先頭から復元して limit で打ち切る
+func ReadFileHead(key string, limit int64) ([]byte, *FileInfo, error) {
+	stat, err := statObject(context.Background(), key, minio.StatObjectOptions{})
+	if err != nil {
+		if isNoSuchObject(err) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, err
+	}
+
+	opts := minio.GetObjectOptions{}
+	_, compressed := compressedSize(stat.UserMetadata)
+	_, encrypted := envelopeFromMetadata(stat.UserMetadata)
+	if !compressed && !encrypted && stat.Size > 0 && limit > 0 {
+		if err := opts.SetRange(0, limit-1); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	reader, info, err := openObject(key, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, max(limit, 0)))
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, info, nil
+}
@@ -0,0 +1,354 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+var (
+	// クォータ設定ファイルのパス（QUOTA_CONFIG、未設定の場合は無制限）
+	QuotaConfigPath = os.Getenv("QUOTA_CONFIG")
+	// 使用量の再集計間隔（QUOTA_RECONCILE_INTERVAL、既定1時間）
+	QuotaReconcileInterval = envDuration("QUOTA_RECONCILE_INTERVAL", time.Hour)
+)
+
+// クォータ上限（0は無制限）
+type QuotaLimit struct {
+	MaxBytes   int64 `json:"maxBytes"`
+	MaxObjects int64 `json:"maxObjects"`
+}
+
+// クォータ設定ファイルの形式
+//
+//	{
+//	  "defaultUser":  {"maxBytes": 10737418240, "maxObjects": 100000},
+//	  "defaultGroup": {"maxBytes": 107374182400},
+//	  "users":  {"admin": {"maxBytes": 0}},
+//	  "groups": {"dev": {"maxBytes": 536870912000}}
+//	}
+type QuotaConfig struct {
+	DefaultUser  QuotaLimit            `json:"defaultUser"`
+	DefaultGroup QuotaLimit            `json:"defaultGroup"`
+	Users        map[string]QuotaLimit `json:"users"`
+	Groups       map[string]QuotaLimit `json:"groups"`
+}
+
+// 所有者（ユーザーまたはグループ）ごとの使用量とクォータ
+type Usage struct {
+	Owner        string    `json:"owner"`
+	Type         string    `json:"type"` // "user" または "group"
+	Bytes        int64     `json:"bytes"`
+	VersionBytes int64     `json:"versionBytes"` // 過去のバージョンの使用量（Bytes に含まれる）
+	Objects      int64     `json:"objects"`
+	MaxBytes     int64     `json:"maxBytes"`
+	MaxObjects   int64     `json:"maxObjects"`
+	ReconciledAt time.Time `json:"reconciledAt"`
+}
+
+type usageCounter struct {
+	bytes        int64
+	versionBytes int64
+	objects      int64
+}
+
+var (
+	quotaMu      sync.Mutex
+	quotaConfig  QuotaConfig
+	usageByOwner = map[string]*usageCounter{}
+	reconciledAt time.Time
+	// 保存中のアップロード・複製が予約している容量（再集計では置き換えない）
+	reservedByOwner = map[string]*usageCounter{}
+)
+
+// オブジェクトキーから使用量の所有者を判定
+//
+//	<ユーザーID>/...           → user:<ユーザーID>
+//	teams/<グループ>/...        → group:<グループ>
+//	.trash/<ユーザーID>/...    → user:<ユーザーID>（ゴミ箱も使用量に含める）
+//	その他の "." で始まる領域  → 対象外
+func quotaOwner(key string) string {
+	parts := strings.SplitN(key, "/", 3)
+	switch {
+	case parts[0] == "teams" && len(parts) >= 2:
+		return "group:" + parts[1]
+	case parts[0] == ".trash" && len(parts) >= 2:
+		return "user:" + parts[1]
+	case strings.HasPrefix(parts[0], ".") || len(parts) < 2:
+		return ""
+	default:
+		return "user:" + parts[0]
+	}
+}
+
+// 所有者に適用されるクォータ上限を取得（呼び出し側でロック済みであること）
+func quotaLimitLocked(owner string) QuotaLimit {
+	kind, name, _ := strings.Cut(owner, ":")
+	if kind == "group" {
+		if limit, ok := quotaConfig.Groups[name]; ok {
+			return limit
+		}
+		return quotaConfig.DefaultGroup
+	}
+	if limit, ok := quotaConfig.Users[name]; ok {
+		return limit
+	}
+	return quotaConfig.DefaultUser
+}
+
+// クォータ設定ファイルを読み込む
+func loadQuotaConfig() error {
+	if QuotaConfigPath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(QuotaConfigPath)
+	if err != nil {
+		return err
+	}
+
+	var config QuotaConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return err
+	}
+
+	quotaMu.Lock()
+	quotaConfig = config
+	quotaMu.Unlock()
+	return nil
+}
+
+// 使用量を増減（SaveFile/DeleteFile等から呼ばれる）
+func trackUsage(key string, bytes, objects int64) {
+	owner := quotaOwner(key)
+	if owner == "" {
+		return
+	}
+
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+
+	counter := usageByOwner[owner]
+	if counter == nil {
+		counter = &usageCounter{}
+		usageByOwner[owner] = counter
+	}
+	counter.bytes += bytes
+	counter.objects += objects
+}
+
+// 過去のバージョンとして残った（負の場合は削除された）容量を使用量に反映
+func trackVersions(key string, bytes int64) {
+	owner := quotaOwner(key)
+	if owner == "" || bytes == 0 {
+		return
+	}
+
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+
+	counter := usageByOwner[owner]
+	if counter == nil {
+		counter = &usageCounter{}
+		usageByOwner[owner] = counter
+	}
+	counter.bytes += bytes
+	counter.versionBytes += bytes
+}
+
+// keyの所有者に bytes / objects を追加してもクォータ内か確認（保存中の予約分も含める）
+func CheckQuota(key string, bytes, objects int64) error {
+	owner := quotaOwner(key)
+	if owner == "" {
+		return nil
+	}
+
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	return checkQuotaLocked(owner, bytes, objects)
+}
+
+// クォータ内か確認（呼び出し側でロック済みであること）
+func checkQuotaLocked(owner string, bytes, objects int64) error {
+	limit := quotaLimitLocked(owner)
+	var current usageCounter
+	if counter := usageByOwner[owner]; counter != nil {
+		current = *counter
+	}
+	if reserved := reservedByOwner[owner]; reserved != nil {
+		current.bytes += reserved.bytes
+		current.objects += reserved.objects
+	}
+
+	if limit.MaxBytes > 0 && current.bytes+bytes > limit.MaxBytes {
+		return ErrQuotaExceeded
+	}
+	if limit.MaxObjects > 0 && current.objects+objects > limit.MaxObjects {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// 保存が終わるまで確保しておくクォータ（所有者が対象外の場合は nil）
+type quotaReservation struct {
+	owner   string
+	bytes   int64
+	objects int64
+}
+
+// クォータを確認し、同じロックの中で bytes / objects を予約する
+// 確認から保存までの間に、同じ所有者の別のアップロードが同じ空き容量を使うことを防ぐ
+// 保存に成功したら使用量に反映してから release を呼ぶこと（失敗した場合も release で解除する）
+func reserveQuota(key string, bytes, objects int64) (*quotaReservation, error) {
+	owner := quotaOwner(key)
+	if owner == "" {
+		return nil, nil
+	}
+
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+
+	if err := checkQuotaLocked(owner, bytes, objects); err != nil {
+		return nil, err
+	}
+	// 上書きで使用量が減る場合は予約しない
+	r := &quotaReservation{owner: owner, bytes: max(bytes, 0), objects: max(objects, 0)}
+	reserved := reservedByOwner[owner]
+	if reserved == nil {
+		reserved = &usageCounter{}
+		reservedByOwner[owner] = reserved
+	}
+	reserved.bytes += r.bytes
+	reserved.objects += r.objects
+	return r, nil
+}
+
+// 予約の一部を解除（使用量に反映済みの分）
+func (r *quotaReservation) consume(bytes, objects int64) {
+	if r == nil {
+		return
+	}
+	bytes, objects = min(max(bytes, 0), r.bytes), min(max(objects, 0), r.objects)
+
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+
+	r.bytes -= bytes
+	r.objects -= objects
+	if reserved := reservedByOwner[r.owner]; reserved != nil {
+		reserved.bytes -= bytes
+		reserved.objects -= objects
+		if reserved.bytes == 0 && reserved.objects == 0 {
+			delete(reservedByOwner, r.owner)
+		}
+	}
+}
+
+// 残りの予約をすべて解除
+func (r *quotaReservation) release() {
+	if r == nil {
+		return
+	}
+	r.consume(r.bytes, r.objects)
+}
+
+// ユーザーの使用量を取得
+func GetUserUsage(userID string) Usage {
+	return getUsage("user:" + userID)
+}
+
+// グループ（チームスペース）の使用量を取得
+func GetGroupUsage(group string) Usage {
+	return getUsage("group:" + group)
+}
+
+func getUsage(owner string) Usage {
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+
+	kind, name, _ := strings.Cut(owner, ":")
+	limit := quotaLimitLocked(owner)
+	usage := Usage{
+		Owner:        name,
+		Type:         kind,
+		MaxBytes:     limit.MaxBytes,
+		MaxObjects:   limit.MaxObjects,
+		ReconciledAt: reconciledAt,
+	}
+	if counter := usageByOwner[owner]; counter != nil {
+		usage.Bytes = counter.bytes
+		usage.VersionBytes = counter.versionBytes
+		usage.Objects = counter.objects
+	}
+	return usage
+}
+
+// バケット全体を走査して使用量を再集計（差分更新のずれを補正）
+// バージョニング有効時は過去のバージョンも容量に含める（削除マーカーは除く）
+func ReconcileUsage() error {
+	totals := map[string]*usageCounter{}
+
+	objectCh := client.ListObjects(context.Background(), bucketName, minio.ListObjectsOptions{
+		Recursive:    true,
+		WithVersions: VersioningEnabled,
+		WithMetadata: true, // 圧縮・暗号化されたファイルのサイズを取得するため
+	})
+	for object := range objectCh {
+		if object.Err != nil {
+			return object.Err
+		}
+		owner := quotaOwner(object.Key)
+		if owner == "" || object.IsDeleteMarker {
+			continue
+		}
+		counter := totals[owner]
+		if counter == nil {
+			counter = &usageCounter{}
+			totals[owner] = counter
+		}
+		if VersioningEnabled && !object.IsLatest {
+			size := versionSize(object)
+			counter.bytes += size
+			counter.versionBytes += size
+			continue
+		}
+		counter.bytes += logicalObject(object).Size
+		counter.objects++
+	}
+
+	quotaMu.Lock()
+	usageByOwner = totals
+	reconciledAt = time.Now()
+	quotaMu.Unlock()
+	return nil
+}
+
+// クォータ設定を読み込み、使用量を集計した上で定期的な再集計を開始
+func StartQuotaReconciler() error {
+	if err := loadQuotaConfig(); err != nil {
+		return err
+	}
+	if err := ReconcileUsage(); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(QuotaReconcileInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := ReconcileUsage(); err != nil {
+				log.Printf("Usage reconciliation error: %v", err)
+			}
+		}
+	}()
+	return nil
+}
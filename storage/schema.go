@@ -0,0 +1,114 @@
+package storage
+
+import "time"
+
+// レスポンスのスキーマバージョン（フィールドの削除・意味の変更時に上げる）
+const SchemaVersion = 1
+
+// 指定階層のファイル/フォルダ一覧（/list）
+type Listing struct {
+	SchemaVersion int        `json:"schemaVersion"`
+	Path          string     `json:"path"`
+	UserID        string     `json:"userID"`
+	Files         []FileInfo `json:"files"`
+	Folders       []string   `json:"folders"`
+	NextCursor    string     `json:"nextCursor"` // 最終ページの場合は ""
+}
+
+// 指定階層の詳細なファイル/フォルダ一覧（/list-details, /list-folders）
+type DetailedListing struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	Path          string       `json:"path"`
+	UserID        string       `json:"userID"`
+	Files         []FileInfo   `json:"files"`
+	Folders       []FolderInfo `json:"folders"`
+	NextCursor    string       `json:"nextCursor"` // 最終ページの場合は ""
+	Statistics    Statistics   `json:"statistics"`
+}
+
+// 一覧の統計情報（total* はサブフォルダを含む合計、direct* は返却したファイルのみ）
+type Statistics struct {
+	TotalFiles     int       `json:"totalFiles"`
+	TotalFolders   int       `json:"totalFolders"`
+	TotalSize      int64     `json:"totalSize"`
+	TotalSizeHuman string    `json:"totalSizeHuman"`
+	LatestModified time.Time `json:"latestModified"`
+	DirectFiles    int       `json:"directFiles"`
+	DirectSize     int64     `json:"directSize"`
+	DirectModified time.Time `json:"directModified"`
+}
+
+// オブジェクトの詳細メタデータ（/metadata）
+type ObjectMetadata struct {
+	SchemaVersion  int               `json:"schemaVersion"`
+	Name           string            `json:"name"`
+	Size           int64             `json:"size"`
+	LastModified   time.Time         `json:"lastModified"`
+	ContentType    string            `json:"contentType"`
+	ETag           string            `json:"etag"`
+	VersionID      string            `json:"versionId"`
+	IsDeleteMarker bool              `json:"isDeleteMarker"`
+	Metadata       map[string]string `json:"metadata"` // ユーザー定義メタデータ
+	Expires        time.Time         `json:"expires"`
+	StorageClass   string            `json:"storageClass"`
+	Tags           map[string]string `json:"tags"`
+	Checksums      *Checksums        `json:"checksums,omitempty"` // アップロード時に計算したチェックサム
+	Image          *ImageMetadata    `json:"image,omitempty"`     // 画像のサイズ・撮影情報
+}
+
+// フォルダ構造のノード（フォルダは Children を持ち、ファイルは Size 等を持つ）
+type TreeNode struct {
+	Name         string      `json:"name"`
+	Type         string      `json:"type"` // "folder" / "file"
+	Size         int64       `json:"size"` // フォルダの場合は配下の合計
+	LastModified time.Time   `json:"lastModified"`
+	ContentType  string      `json:"contentType,omitempty"`
+	Children     []*TreeNode `json:"children,omitempty"`
+}
+
+// 名前で子ノードを取得（存在しなければ作成）
+func (n *TreeNode) child(name, nodeType string) *TreeNode {
+	for _, c := range n.Children {
+		if c.Name == name && c.Type == nodeType {
+			return c
+		}
+	}
+	c := &TreeNode{Name: name, Type: nodeType}
+	n.Children = append(n.Children, c)
+	return c
+}
+
+// 検索結果（/search）
+type SearchResults struct {
+	SchemaVersion int         `json:"schemaVersion"`
+	Query         string      `json:"query"`
+	Results       []SearchHit `json:"results"`
+	NextCursor    string      `json:"nextCursor"` // 最終ページの場合は ""
+}
+
+// 検索に一致したファイル
+type SearchHit struct {
+	Space        string            `json:"space"` // 個人スペースの場合は ""、チームスペースの場合はグループ名
+	Path         string            `json:"path"`  // スペース内のフォルダパス
+	Name         string            `json:"name"`
+	Size         int64             `json:"size"`
+	LastModified time.Time         `json:"lastModified"`
+	ContentType  string            `json:"contentType"`
+	Tags         map[string]string `json:"tags,omitempty"`
+	Image        *ImageMetadata    `json:"image,omitempty"`
+}
+
+// 全文検索結果（/search/content）
+type FullTextResults struct {
+	SchemaVersion int           `json:"schemaVersion"`
+	Query         string        `json:"query"`
+	Total         uint64        `json:"total"` // 一致した件数（閲覧範囲内、ページング前）
+	Results       []FullTextHit `json:"results"`
+}
+
+// 全文検索に一致したファイル（スコアの高い順）
+type FullTextHit struct {
+	SearchHit
+	Score    float64  `json:"score"`
+	Snippets []string `json:"snippets"` // 一致箇所を <mark> で囲んだHTML断片
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"errors"
+	"path"
+	"strings"
+	"time"
+)
+
+var ErrInvalidPattern = errors.New("invalid name pattern")
+
+// ファイル検索の条件
+type SearchQuery struct {
+	Roots []string // 検索対象のプレフィックス（"user123/", "teams/dev/" など）
+	Name  string   // 名前の部分一致（* ? [ を含む場合はglob、大文字小文字を区別しない）
+
+	// 拡張子・コンテンツタイプ・サイズ・更新日時・タグの絞り込みと並び順・ページング
+	// name順はスペース内のパス順になる
+	Options ListOptions
+
+	// 画像のメタデータでの絞り込み（指定した場合は画像のみ対象、Camera は大文字小文字を区別しない部分一致）
+	Camera      string
+	TakenAfter  time.Time
+	TakenBefore time.Time
+	MinWidth    int
+	MinHeight   int
+}
+
+// 画像のメタデータが検索条件に一致するか判定
+func (q SearchQuery) matchesImage(image *ImageMetadata) bool {
+	if q.Camera == "" && q.TakenAfter.IsZero() && q.TakenBefore.IsZero() && q.MinWidth == 0 && q.MinHeight == 0 {
+		return true
+	}
+	if image == nil || image.Width < q.MinWidth || image.Height < q.MinHeight {
+		return false
+	}
+	if q.Camera != "" && !strings.Contains(strings.ToLower(image.Camera), strings.ToLower(q.Camera)) {
+		return false
+	}
+	if !q.TakenAfter.IsZero() || !q.TakenBefore.IsZero() {
+		taken, ok := image.TakenTime()
+		if !ok || (!q.TakenAfter.IsZero() && taken.Before(q.TakenAfter)) || (!q.TakenBefore.IsZero() && taken.After(q.TakenBefore)) {
+			return false
+		}
+	}
+	return true
+}
+
+// 名前が検索条件に一致するか判定
+func (q SearchQuery) matchesName(name string) bool {
+	if q.Name == "" {
+		return true
+	}
+	pattern := strings.ToLower(q.Name)
+	name = strings.ToLower(name)
+	if strings.ContainsAny(pattern, "*?[") {
+		matched, _ := path.Match(pattern, name)
+		return matched
+	}
+	return strings.Contains(name, pattern)
+}
+
+// メタデータインデックスからファイルを検索（バケットは走査しない）
+func SearchFiles(q SearchQuery) ([]SearchHit, string, error) {
+	if strings.ContainsAny(q.Name, "*?[") {
+		if _, err := path.Match(strings.ToLower(q.Name), ""); err != nil {
+			return nil, "", ErrInvalidPattern
+		}
+	}
+
+	opts := q.Options
+	opts.normalize()
+	cursor, err := decodeCursor(opts.Cursor, opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	entries := []listEntry{}
+
+	indexMu.RLock()
+	for key, indexed := range metadataIndex {
+		if !underAnyRoot(key, q.Roots) {
+			continue
+		}
+		if !q.matchesName(path.Base(key)) || !q.matchesImage(indexed.Image) {
+			continue
+		}
+		entry := listEntry{
+			Name:         key,
+			Size:         indexed.Size,
+			LastModified: indexed.LastModified,
+			ContentType:  indexed.ContentType,
+			Tags:         indexed.Tags,
+			key:          key,
+			image:        indexed.Image,
+		}
+		if !opts.matches(entry) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	indexMu.RUnlock()
+
+	page, next := paginate(entries, opts, cursor)
+
+	hits := make([]SearchHit, 0, len(page))
+	for _, entry := range page {
+		hits = append(hits, toSearchHit(entry))
+	}
+	return hits, next, nil
+}
+
+func underAnyRoot(key string, roots []string) bool {
+	for _, root := range roots {
+		if strings.HasPrefix(key, strings.TrimSuffix(root, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// オブジェクトキーをスペース・パス・ファイル名に分解
+//
+//	user123/docs/a.txt    → space "",    path "docs"
+//	teams/dev/specs/b.md  → space "dev", path "specs"
+func toSearchHit(entry listEntry) SearchHit {
+	rel := entry.key
+	space := ""
+	if strings.HasPrefix(rel, "teams/") {
+		space, rel, _ = strings.Cut(strings.TrimPrefix(rel, "teams/"), "/")
+	} else {
+		_, rel, _ = strings.Cut(rel, "/")
+	}
+
+	dir := path.Dir(rel)
+	if dir == "." {
+		dir = ""
+	}
+	return SearchHit{
+		Space:        space,
+		Path:         dir,
+		Name:         path.Base(rel),
+		Size:         entry.Size,
+		LastModified: entry.LastModified,
+		ContentType:  entry.ContentType,
+		Tags:         entry.Tags,
+		Image:        entry.image,
+	}
+}
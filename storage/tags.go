@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/textproto"
+	"slices"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/tags"
+)
+
+var ErrInvalidMetadata = errors.New("invalid tags or metadata")
+
+// ユーザー定義メタデータの合計サイズ上限（S3の制限）
+const maxUserMetadataSize = 2 << 10
+
+// アップロード時に付与するメタデータとタグ
+type UploadOptions struct {
+	Metadata map[string]string // x-amz-meta-* として保存
+	Tags     map[string]string // オブジェクトタグ（最大10個）
+	// コンテンツタイプ（DetectContentType で判定した値、空の場合は未設定のまま保存）
+	ContentType string
+	// クライアントが送信したチェックサム（一致しない場合は保存しない）
+	Checksums Checksums
+	// JPEG・PNGの位置情報（EXIFのGPS情報・XMP）を削除してから保存するか
+	StripLocation bool
+}
+
+func (o UploadOptions) validate() error {
+	if o.ContentType != "" {
+		if err := CheckContentType(o.ContentType); err != nil {
+			return err
+		}
+	}
+	if len(o.Tags) > 0 {
+		if _, err := tags.NewTags(o.Tags, true); err != nil {
+			return errors.Join(ErrInvalidMetadata, err)
+		}
+	}
+
+	size := 0
+	for key, value := range o.Metadata {
+		if !isMetadataKey(key) || !isMetadataValue(value) || isReservedMetadataKey(key) {
+			return ErrInvalidMetadata
+		}
+		size += len(key) + len(value)
+	}
+	if size > maxUserMetadataSize {
+		return ErrInvalidMetadata
+	}
+	return nil
+}
+
+// 内部で使用するメタデータのキー（利用者による指定・表示の対象外）
+var reservedMetadataKeys = []string{
+	checksumMD5Key, checksumSHA256Key,
+	dedupBlobKey, dedupSizeKey,
+	envelopeKeyIDKey, envelopeDataKeyKey, envelopeSizeKey,
+	compressionKey, compressionSizeKey, compressionFrameKey,
+	imageWidthKey, imageHeightKey, imageCameraKey, imageTakenKey,
+}
+
+func isReservedMetadataKey(key string) bool {
+	return slices.ContainsFunc(reservedMetadataKeys, func(reserved string) bool {
+		return strings.EqualFold(key, reserved)
+	})
+}
+
+// 一覧で取得したユーザー定義メタデータのキーをStatObjectと同じ形式（"X-Amz-Meta-" なし）にそろえる
+func listedMetadata(metadata map[string]string) map[string]string {
+	const prefix = "X-Amz-Meta-"
+	normalized := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		if len(key) > len(prefix) && strings.EqualFold(key[:len(prefix)], prefix) {
+			key = key[len(prefix):]
+		}
+		normalized[textproto.CanonicalMIMEHeaderKey(key)] = value
+	}
+	return normalized
+}
+
+// 内部で使用するキーを除いたユーザー定義メタデータ
+func publicMetadata(metadata map[string]string) map[string]string {
+	filtered := maps.Clone(metadata)
+	maps.DeleteFunc(filtered, func(key, _ string) bool {
+		return isReservedMetadataKey(key)
+	})
+	return filtered
+}
+
+// HTTPヘッダー名として使える文字のみ許可
+func isMetadataKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// HTTPヘッダー値として使える印字可能なASCII文字のみ許可
+func isMetadataValue(value string) bool {
+	for _, r := range value {
+		if r < 0x20 || r > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// オブジェクトのタグを取得
+func GetTags(key string) (map[string]string, error) {
+	objectTags, err := client.GetObjectTagging(context.Background(), bucketName, key, minio.GetObjectTaggingOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return objectTags.ToMap(), nil
+}
+
+// オブジェクトのタグを設定（replace=false の場合は既存のタグに追加・上書き）
+func SetTags(key string, newTags map[string]string, replace bool) (map[string]string, error) {
+	merged := map[string]string{}
+	if !replace {
+		current, err := GetTags(key)
+		if err != nil {
+			return nil, err
+		}
+		maps.Copy(merged, current)
+	}
+	maps.Copy(merged, newTags)
+	return putTags(key, merged)
+}
+
+// オブジェクトのタグを削除（keysが空の場合は全て削除）
+func RemoveTags(key string, keys []string) (map[string]string, error) {
+	remaining := map[string]string{}
+	if len(keys) > 0 {
+		current, err := GetTags(key)
+		if err != nil {
+			return nil, err
+		}
+		remaining = current
+		for _, k := range keys {
+			delete(remaining, k)
+		}
+	}
+	return putTags(key, remaining)
+}
+
+func putTags(key string, tagMap map[string]string) (map[string]string, error) {
+	ctx := context.Background()
+
+	var err error
+	if len(tagMap) == 0 {
+		err = client.RemoveObjectTagging(ctx, bucketName, key, minio.RemoveObjectTaggingOptions{})
+	} else {
+		objectTags, tagErr := tags.NewTags(tagMap, true)
+		if tagErr != nil {
+			return nil, errors.Join(ErrInvalidMetadata, tagErr)
+		}
+		err = client.PutObjectTagging(ctx, bucketName, key, objectTags, minio.PutObjectTaggingOptions{})
+	}
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	setIndexTags(key, tagMap)
+	return tagMap, nil
+}
@@ -0,0 +1,392 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"mime"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/minio/minio-go/v7"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrThumbnailUnavailable = errors.New("thumbnail is not available for this file")
+	ErrThumbnailPending     = errors.New("thumbnail is being generated")
+)
+
+var (
+	// 画像のサムネイルを生成するか（THUMBNAIL_ENABLED、既定true）
+	ThumbnailEnabled = envBool("THUMBNAIL_ENABLED", true)
+	// 生成するサイズ（THUMBNAIL_SIZES、長辺のピクセル数をカンマ区切り、既定 "128,256,512"）
+	ThumbnailSizes = parseThumbnailSizes(envString("THUMBNAIL_SIZES", "128,256,512"))
+	// 生成する形式（THUMBNAIL_FORMATS、"jpeg" / "webp" をカンマ区切り、既定 "jpeg,webp"）
+	ThumbnailFormats = splitEnvList(envString("THUMBNAIL_FORMATS", "jpeg,webp"))
+	// JPEGの品質（THUMBNAIL_JPEG_QUALITY、既定80）
+	ThumbnailJPEGQuality = envInt64("THUMBNAIL_JPEG_QUALITY", 80)
+	// サムネイルを生成する元画像の最大サイズ（THUMBNAIL_MAX_SOURCE_SIZE、既定50MiB）
+	ThumbnailMaxSourceSize = envInt64("THUMBNAIL_MAX_SOURCE_SIZE", 50<<20)
+	// 元画像の最大画素数（THUMBNAIL_MAX_PIXELS、既定5000万。展開後のメモリ使用量を抑える）
+	ThumbnailMaxPixels = envInt64("THUMBNAIL_MAX_PIXELS", 50_000_000)
+	// サムネイルを生成するワーカー数（THUMBNAIL_WORKERS、既定1）
+	ThumbnailWorkers = envInt64("THUMBNAIL_WORKERS", 1)
+)
+
+// サムネイルの保存先（"." で始まるため一覧・検索・使用量には含まれない）
+const thumbnailPrefix = ".thumbnails/"
+
+// 生成待ちキューの長さ（溢れた分は表示時またはインデックスの再構築時に補う）
+const thumbnailQueueSize = 10000
+
+// 生成元のバージョン（サイズと更新日時）を保存するメタデータのキー
+const thumbnailSourceKey = "Thumbnail-Source"
+
+// サムネイルを生成する元画像のコンテンツタイプ
+var thumbnailSourceTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+var (
+	thumbnailQueue  chan string
+	thumbnailMu     sync.Mutex
+	thumbnailQueued = map[string]bool{}
+)
+
+// "128,256,512" 形式のサイズ指定を昇順の一覧に変換（不正な値は無視する）
+func parseThumbnailSizes(value string) []int {
+	var sizes []int
+	for _, part := range strings.Split(value, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || size <= 0 || size > 4096 || slices.Contains(sizes, size) {
+			continue
+		}
+		sizes = append(sizes, size)
+	}
+	slices.Sort(sizes)
+	return sizes
+}
+
+// 形式に対応する拡張子とコンテンツタイプ
+func thumbnailFormatInfo(format string) (ext, contentType string) {
+	if format == "webp" {
+		return "webp", "image/webp"
+	}
+	return "jpg", "image/jpeg"
+}
+
+// 対応している形式か（THUMBNAIL_FORMATS に含まれるもののみ）
+func IsThumbnailFormat(format string) bool {
+	return slices.Contains(ThumbnailFormats, format)
+}
+
+// 元のキーに対応するサムネイルのキー（.thumbnails/<元のキー>/<サイズ>.<拡張子>）
+func thumbnailKey(key string, size int, format string) string {
+	ext, _ := thumbnailFormatInfo(format)
+	return fmt.Sprintf("%s%s/%d.%s", thumbnailPrefix, key, size, ext)
+}
+
+// 要求されたサイズ以上で最小の生成済みサイズ（なければ最大のサイズ）
+func thumbnailSizeFor(requested int) int {
+	for _, size := range ThumbnailSizes {
+		if size >= requested {
+			return size
+		}
+	}
+	return ThumbnailSizes[len(ThumbnailSizes)-1]
+}
+
+func thumbnailVersion(info minio.ObjectInfo) string {
+	return fmt.Sprintf("%d-%d", info.Size, info.LastModified.UnixNano())
+}
+
+// サムネイルを生成する対象か（暗号化フォルダの画像は復号した内容を残さないため対象外）
+func isThumbnailSource(key string, info minio.ObjectInfo) bool {
+	if info.Size > ThumbnailMaxSourceSize {
+		return false
+	}
+	if _, ok := envelopeFromMetadata(info.UserMetadata); ok {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(guessContentType(key, info.ContentType))
+	return err == nil && slices.Contains(thumbnailSourceTypes, strings.ToLower(mediaType))
+}
+
+// サムネイル生成ワーカーを起動
+func StartThumbnailer() {
+	ThumbnailFormats = slices.DeleteFunc(ThumbnailFormats, func(format string) bool {
+		return format != "jpeg" && format != "webp"
+	})
+	if !ThumbnailEnabled || len(ThumbnailSizes) == 0 || len(ThumbnailFormats) == 0 {
+		return
+	}
+	thumbnailQueue = make(chan string, thumbnailQueueSize)
+	for i := int64(0); i < max(ThumbnailWorkers, 1); i++ {
+		go runThumbnailWorker()
+	}
+}
+
+// キーをサムネイルの生成待ちに追加（キューが満杯の場合は諦める）
+func enqueueThumbnail(key string) {
+	if thumbnailQueue == nil || !isIndexable(key) {
+		return
+	}
+
+	thumbnailMu.Lock()
+	if thumbnailQueued[key] {
+		thumbnailMu.Unlock()
+		return
+	}
+	thumbnailQueued[key] = true
+	thumbnailMu.Unlock()
+
+	select {
+	case thumbnailQueue <- key:
+	default:
+		thumbnailMu.Lock()
+		delete(thumbnailQueued, key)
+		thumbnailMu.Unlock()
+		log.Printf("Thumbnail queue full, %s will be generated on demand", key)
+	}
+}
+
+func runThumbnailWorker() {
+	for key := range thumbnailQueue {
+		if err := generateThumbnails(key); err != nil {
+			log.Printf("Thumbnail generation of %s failed: %v", key, err)
+		}
+	}
+}
+
+// オブジェクトの現在の状態にサムネイルを合わせる（削除済み・対象外の場合はサムネイルを削除）
+func generateThumbnails(key string) (err error) {
+	// 不正な画像でデコーダがpanicしてもワーカーを止めない
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic during decoding: %v", r)
+		}
+	}()
+
+	thumbnailMu.Lock()
+	delete(thumbnailQueued, key)
+	thumbnailMu.Unlock()
+
+	ctx := context.Background()
+	info, err := statObject(ctx, key, minio.StatObjectOptions{})
+	if isNoSuchObject(err) {
+		return removeThumbnails(ctx, key, nil)
+	}
+	if err != nil {
+		return err
+	}
+	if !isThumbnailSource(key, info) {
+		return removeThumbnails(ctx, key, nil)
+	}
+
+	// 生成済みのサムネイルが現在の内容のものなら何もしない
+	version := thumbnailVersion(info)
+	largest := ThumbnailSizes[len(ThumbnailSizes)-1]
+	if current, _, err := statThumbnail(ctx, key, thumbnailKey(key, largest, ThumbnailFormats[0])); err == nil &&
+		current.UserMetadata[thumbnailSourceKey] == version {
+		return nil
+	}
+
+	obj, objInfo, err := getObject(ctx, key, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	reader, _, err := decompressObject(obj, objInfo)
+	if err != nil {
+		obj.Close()
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, ThumbnailMaxSourceSize))
+	reader.Close()
+	if err != nil {
+		return err
+	}
+
+	// 展開前に画素数を確認（小さなファイルで巨大な画像を宣言する攻撃への対策）
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return removeThumbnails(ctx, key, nil)
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > ThumbnailMaxPixels {
+		return removeThumbnails(ctx, key, nil)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return removeThumbnails(ctx, key, nil)
+	}
+
+	// 大きいサイズから順に、直前の縮小結果をさらに縮小する
+	keep := map[string]bool{}
+	src := img
+	for i := len(ThumbnailSizes) - 1; i >= 0; i-- {
+		size := ThumbnailSizes[i]
+		src = scaleImage(src, size)
+		for _, format := range ThumbnailFormats {
+			thumbKey := thumbnailKey(key, size, format)
+			if err := putThumbnail(ctx, key, thumbKey, src, format, version); err != nil {
+				return err
+			}
+			keep[thumbKey] = true
+		}
+	}
+	// 設定から外れたサイズ・形式のサムネイルを削除
+	return removeThumbnails(ctx, key, keep)
+}
+
+// 長辺が size に収まるよう縦横比を保って縮小（小さい画像は拡大しない）
+func scaleImage(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= size && height <= size {
+		return src
+	}
+	if width >= height {
+		height = max(height*size/width, 1)
+		width = size
+	} else {
+		width = max(width*size/height, 1)
+		height = size
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+	return dst
+}
+
+// サムネイルを指定の形式で保存（元のファイルと同じ鍵で暗号化する）
+func putThumbnail(ctx context.Context, key, thumbKey string, img image.Image, format, version string) error {
+	var buf bytes.Buffer
+	if format == "webp" {
+		if err := nativewebp.Encode(&buf, img, nil); err != nil {
+			return err
+		}
+	} else {
+		// JPEGは透過を扱えないため白背景に合成する
+		opaque := image.NewRGBA(img.Bounds())
+		draw.Draw(opaque, opaque.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(opaque, opaque.Bounds(), img, img.Bounds().Min, draw.Over)
+		if err := jpeg.Encode(&buf, opaque, &jpeg.Options{Quality: int(min(max(ThumbnailJPEGQuality, 1), 100))}); err != nil {
+			return err
+		}
+	}
+
+	_, contentType := thumbnailFormatInfo(format)
+	_, err := client.PutObject(ctx, bucketName, thumbKey, &buf, int64(buf.Len()), minio.PutObjectOptions{
+		ContentType:          contentType,
+		UserMetadata:         map[string]string{thumbnailSourceKey: version},
+		ServerSideEncryption: writeSSE(key),
+	})
+	return err
+}
+
+// サムネイルの情報を取得（暗号化設定は元のファイルのものを試す）
+func statThumbnail(ctx context.Context, key, thumbKey string) (minio.ObjectInfo, minio.GetObjectOptions, error) {
+	var info minio.ObjectInfo
+	var err error
+	for _, sse := range readSSEAttempts(key) {
+		info, err = client.StatObject(ctx, bucketName, thumbKey, minio.StatObjectOptions{ServerSideEncryption: sse})
+		if err == nil {
+			return info, minio.GetObjectOptions{ServerSideEncryption: sse}, nil
+		}
+		if isNoSuchObject(err) {
+			break
+		}
+	}
+	return info, minio.GetObjectOptions{}, err
+}
+
+// キーのサムネイルを削除（keep に含まれるものは残す）
+func removeThumbnails(ctx context.Context, key string, keep map[string]bool) error {
+	objectCh := client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: thumbnailPrefix + key + "/"})
+	for object := range objectCh {
+		if object.Err != nil {
+			return object.Err
+		}
+		// 同名のフォルダ配下のファイルのサムネイルは対象外
+		if strings.HasSuffix(object.Key, "/") || keep[object.Key] {
+			continue
+		}
+		if err := client.RemoveObject(ctx, bucketName, object.Key, minio.RemoveObjectOptions{}); err != nil && !isNoSuchObject(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// 元のファイルが削除されたサムネイルを削除（生成キューから漏れた削除を補う）
+func syncThumbnails() error {
+	if thumbnailQueue == nil {
+		return nil
+	}
+	ctx := context.Background()
+	objectCh := client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: thumbnailPrefix, Recursive: true})
+	for object := range objectCh {
+		if object.Err != nil {
+			return object.Err
+		}
+		source := path.Dir(strings.TrimPrefix(object.Key, thumbnailPrefix))
+		if indexHasKey(source) {
+			continue
+		}
+		if err := client.RemoveObject(ctx, bucketName, object.Key, minio.RemoveObjectOptions{}); err != nil && !isNoSuchObject(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// 画像ファイルのサムネイルを開く（長辺が size 以上で最小の生成済みサイズを返す）
+// 未生成または古い場合は生成待ちに追加して ErrThumbnailPending を返す
+func OpenThumbnail(key string, size int, format string) (io.ReadSeekCloser, *FileInfo, error) {
+	if thumbnailQueue == nil || !IsThumbnailFormat(format) {
+		return nil, nil, ErrThumbnailUnavailable
+	}
+
+	ctx := context.Background()
+	info, err := statObject(ctx, key, minio.StatObjectOptions{})
+	if isNoSuchObject(err) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !isThumbnailSource(key, info) {
+		return nil, nil, ErrThumbnailUnavailable
+	}
+
+	thumbKey := thumbnailKey(key, thumbnailSizeFor(size), format)
+	thumbInfo, opts, err := statThumbnail(ctx, key, thumbKey)
+	if isNoSuchObject(err) || (err == nil && thumbInfo.UserMetadata[thumbnailSourceKey] != thumbnailVersion(info)) {
+		enqueueThumbnail(key)
+		return nil, nil, ErrThumbnailPending
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	obj, err := client.GetObject(ctx, bucketName, thumbKey, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return obj, &FileInfo{
+		Name:         thumbKey,
+		Size:         thumbInfo.Size,
+		LastModified: thumbInfo.LastModified,
+		ContentType:  thumbInfo.ContentType,
+	}, nil
+}
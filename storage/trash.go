@@ -0,0 +1,292 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ゴミ箱のオブジェクトキー構成
+//
+//	.trash/<所有者>/<エントリID>/.trashinfo   削除情報（JSON）
+//	.trash/<所有者>/<エントリID>/data/<名前>  削除されたファイル/フォルダ
+const (
+	trashPrefix   = ".trash/"
+	trashInfoName = ".trashinfo"
+	trashDataDir  = "data/"
+)
+
+var (
+	// ゴミ箱の保持期間（TRASH_RETENTION、既定30日）
+	TrashRetention = envDuration("TRASH_RETENTION", 30*24*time.Hour)
+	// 期限切れエントリの削除間隔（TRASH_PURGE_INTERVAL、既定1時間）
+	TrashPurgeInterval = envDuration("TRASH_PURGE_INTERVAL", time.Hour)
+)
+
+// ゴミ箱エントリ（1回の削除操作に対応）
+type TrashEntry struct {
+	ID           string    `json:"id"`
+	OriginalPath string    `json:"originalPath"` // 所有者ルートからの相対パス
+	Type         string    `json:"type"`         // "file" または "folder"
+	Size         int64     `json:"size"`
+	ObjectCount  int       `json:"objectCount"`
+	DeletedAt    time.Time `json:"deletedAt"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
+func trashRoot(owner string) string {
+	return trashPrefix + owner + "/"
+}
+
+func trashEntryPrefix(owner, id string) string {
+	return trashRoot(owner) + id + "/"
+}
+
+func trashDataKey(owner string, entry *TrashEntry) string {
+	return trashEntryPrefix(owner, entry.ID) + trashDataDir + path.Base(entry.OriginalPath)
+}
+
+// 時刻順に並ぶ一意なエントリIDを生成
+func newTrashID(now time.Time) string {
+	buf := make([]byte, 4)
+	rand.Read(buf)
+	return now.UTC().Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(buf)
+}
+
+func newTrashEntry(owner, key, entryType string) *TrashEntry {
+	now := time.Now()
+	return &TrashEntry{
+		ID:           newTrashID(now),
+		OriginalPath: strings.TrimSuffix(strings.TrimPrefix(key, owner+"/"), "/"),
+		Type:         entryType,
+		DeletedAt:    now,
+		ExpiresAt:    now.Add(TrashRetention),
+	}
+}
+
+// 削除情報を保存
+func writeTrashInfo(owner string, entry *TrashEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	key := trashEntryPrefix(owner, entry.ID) + trashInfoName
+	_, err = client.PutObject(context.Background(), bucketName, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType:          "application/json",
+		ServerSideEncryption: writeSSE(key),
+	})
+	return err
+}
+
+// 削除情報を読み込み
+func readTrashInfo(owner, id string) (*TrashEntry, error) {
+	obj, _, err := getObject(context.Background(), trashEntryPrefix(owner, id)+trashInfoName, minio.GetObjectOptions{})
+	if err != nil {
+		if isNoSuchObject(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	defer obj.Close()
+
+	var entry TrashEntry
+	if err := json.NewDecoder(obj).Decode(&entry); err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	entry.ExpiresAt = entry.DeletedAt.Add(TrashRetention)
+	return &entry, nil
+}
+
+func removeTrashInfo(owner, id string) error {
+	return client.RemoveObject(context.Background(), bucketName, trashEntryPrefix(owner, id)+trashInfoName, minio.RemoveObjectOptions{})
+}
+
+// ファイルをゴミ箱へ移動
+func TrashFile(owner, key string) (*TrashEntry, error) {
+	info, err := statObject(context.Background(), key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	entry := newTrashEntry(owner, key, "file")
+	entry.Size = info.Size
+	entry.ObjectCount = 1
+
+	// 削除情報を先に書き込み、移動に失敗した場合は取り消す
+	if err := writeTrashInfo(owner, entry); err != nil {
+		return nil, err
+	}
+	if err := MoveFile(key, trashDataKey(owner, entry), true); err != nil {
+		removeTrashInfo(owner, entry.ID)
+		return nil, err
+	}
+	return entry, nil
+}
+
+// フォルダをゴミ箱へ移動（一部失敗した場合は移動できた分のみエントリに含まれる）
+func TrashFolder(owner, prefix string) (*TrashEntry, *MoveResult, error) {
+	entry := newTrashEntry(owner, prefix, "folder")
+
+	if err := writeTrashInfo(owner, entry); err != nil {
+		return nil, nil, err
+	}
+	result, err := MoveFolder(prefix, trashDataKey(owner, entry), true, nil)
+	if err != nil {
+		removeTrashInfo(owner, entry.ID)
+		return nil, nil, err
+	}
+	if result.Moved == 0 {
+		removeTrashInfo(owner, entry.ID)
+		return nil, result, nil
+	}
+
+	// 実際に移動できた件数・サイズで削除情報を更新
+	entry.Size = result.TotalSize
+	entry.ObjectCount = result.Moved
+	if err := writeTrashInfo(owner, entry); err != nil {
+		return nil, nil, err
+	}
+	return entry, result, nil
+}
+
+// ゴミ箱の一覧を取得（新しい順）
+func ListTrash(owner string) ([]TrashEntry, error) {
+	entries := []TrashEntry{}
+
+	objectCh := client.ListObjects(context.Background(), bucketName, minio.ListObjectsOptions{
+		Prefix:    trashRoot(owner),
+		Recursive: false,
+	})
+	for object := range objectCh {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		if !strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+
+		id := strings.TrimSuffix(strings.TrimPrefix(object.Key, trashRoot(owner)), "/")
+		entry, err := readTrashInfo(owner, id)
+		if err != nil {
+			log.Printf("Skipping trash entry %s: %v", object.Key, err)
+			continue
+		}
+		entries = append(entries, *entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DeletedAt.After(entries[j].DeletedAt)
+	})
+	return entries, nil
+}
+
+// ゴミ箱のエントリを元の場所に復元
+// フォルダの場合は元のフォルダに統合し、全て復元できた場合のみエントリを削除する
+func RestoreTrash(owner, id string, overwrite bool) (*TrashEntry, *MoveResult, error) {
+	entry, err := readTrashInfo(owner, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	originalKey := owner + "/" + entry.OriginalPath
+
+	if entry.Type == "file" {
+		if err := MoveFile(trashDataKey(owner, entry), originalKey, overwrite); err != nil {
+			return entry, nil, err
+		}
+		return entry, nil, removeTrashInfo(owner, id)
+	}
+
+	result, err := MoveFolder(trashDataKey(owner, entry), originalKey, overwrite, nil)
+	if err != nil {
+		return entry, nil, err
+	}
+	if result.Completed {
+		return entry, result, removeTrashInfo(owner, id)
+	}
+	return entry, result, nil
+}
+
+// ゴミ箱を完全に削除（idを指定した場合はそのエントリのみ）
+func EmptyTrash(owner, id string) (*DeleteResult, error) {
+	prefix := trashRoot(owner)
+	if id != "" {
+		prefix = trashEntryPrefix(owner, id)
+	}
+
+	result, err := DeleteFolder(prefix, false)
+	if err == ErrNotFound && id == "" {
+		// 空のゴミ箱を空にする操作は成功扱い
+		return &DeleteResult{Prefix: prefix, Errors: []ObjectError{}}, nil
+	}
+	return result, err
+}
+
+// 保持期間を過ぎたゴミ箱エントリを完全に削除し、削除したエントリ数を返す
+// 削除日時は .trashinfo の最終更新日時で判定する
+func PurgeExpiredTrash() (int, error) {
+	cutoff := time.Now().Add(-TrashRetention)
+	purged := 0
+
+	objectCh := client.ListObjects(context.Background(), bucketName, minio.ListObjectsOptions{
+		Prefix:    trashPrefix,
+		Recursive: true,
+	})
+
+	var expired []string
+	for object := range objectCh {
+		if object.Err != nil {
+			return 0, object.Err
+		}
+		if path.Base(object.Key) == trashInfoName && object.LastModified.Before(cutoff) {
+			expired = append(expired, strings.TrimSuffix(object.Key, trashInfoName))
+		}
+	}
+
+	for _, prefix := range expired {
+		result, err := DeleteFolder(prefix, false)
+		if err != nil {
+			log.Printf("Failed to purge trash entry %s: %v", prefix, err)
+			continue
+		}
+		if result.Failed > 0 {
+			log.Printf("Partially purged trash entry %s: %d errors", prefix, result.Failed)
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// 期限切れゴミ箱エントリを定期的に削除するバックグラウンド処理を開始
+func StartTrashPurger() {
+	go func() {
+		ticker := time.NewTicker(TrashPurgeInterval)
+		defer ticker.Stop()
+
+		for {
+			purged, err := PurgeExpiredTrash()
+			if err != nil {
+				log.Printf("Trash purge error: %v", err)
+			} else if purged > 0 {
+				log.Printf("Purged %d expired trash entries", purged)
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var (
+	// お気に入り・最近使ったファイル・アクティビティの保存先バケット（USER_DATA_BUCKET、既定 userdata）
+	// ファイル本体のバケットとは分けて保存し、一覧・使用量・検索の対象にしない
+	UserDataBucket = envString("USER_DATA_BUCKET", "userdata")
+	// 最近使ったファイル・アクティビティの書き出し間隔（USER_DATA_FLUSH_INTERVAL、既定5秒）
+	UserDataFlushInterval = envDuration("USER_DATA_FLUSH_INTERVAL", 5*time.Second)
+	// 最近使ったファイル・アクティビティをメモリ上に保持する期間（USER_DATA_CACHE_TTL、既定30分。最後に参照されてからの時間）
+	UserDataCacheTTL = envDuration("USER_DATA_CACHE_TTL", 30*time.Minute)
+)
+
+// ユーザーデータ用バケットを作成
+func ensureUserDataBucket() error {
+	ctx := context.Background()
+	exists, err := client.BucketExists(ctx, UserDataBucket)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	return client.MakeBucket(ctx, UserDataBucket, minio.MakeBucketOptions{})
+}
+
+// ユーザーデータをJSONとして読み込む（存在しない場合は v を変更せず nil を返す）
+func loadUserData(key string, v any) error {
+	obj, err := client.GetObject(context.Background(), UserDataBucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	err = json.NewDecoder(obj).Decode(v)
+	if err != nil && minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil
+	}
+	return err
+}
+
+// ユーザーデータをJSONとして保存
+func saveUserData(key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = client.PutObject(context.Background(), UserDataBucket, key, bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: "application/json"})
+	return err
+}
+
+// 新しい順に件数上限まで保持する記録（メモリ上で更新し、定期的にバケットへ書き出す）
+type bufferedLog[T any] struct {
+	mu     sync.Mutex
+	prefix string // バケット内のキーの接頭辞
+	limit  int
+	logs   map[string][]T
+	dirty  map[string]bool
+	used   map[string]time.Time // 最後に参照された時刻（期限切れの記録はメモリから取り除く）
+}
+
+func newBufferedLog[T any](prefix string, limit int) *bufferedLog[T] {
+	return &bufferedLog[T]{
+		prefix: prefix,
+		limit:  limit,
+		logs:   map[string][]T{},
+		dirty:  map[string]bool{},
+		used:   map[string]time.Time{},
+	}
+}
+
+// 呼び出し側でロック済みであること
+func (b *bufferedLog[T]) loadLocked(id string) ([]T, error) {
+	b.used[id] = time.Now()
+	if items, ok := b.logs[id]; ok {
+		return items, nil
+	}
+	var items []T
+	if err := loadUserData(b.prefix+id+".json", &items); err != nil {
+		return nil, err
+	}
+	b.logs[id] = items
+	return items, nil
+}
+
+// 記録を取得（新しい順）
+func (b *bufferedLog[T]) get(id string) ([]T, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	items, err := b.loadLocked(id)
+	if err != nil {
+		return nil, err
+	}
+	return append([]T(nil), items...), nil
+}
+
+// 先頭に追加（replaces が true を返す既存の記録は取り除く）
+func (b *bufferedLog[T]) prepend(id string, item T, replaces func(T) bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	items, err := b.loadLocked(id)
+	if err != nil {
+		return err
+	}
+
+	updated := make([]T, 0, len(items)+1)
+	updated = append(updated, item)
+	for _, existing := range items {
+		if replaces != nil && replaces(existing) {
+			continue
+		}
+		updated = append(updated, existing)
+	}
+	if b.limit > 0 && len(updated) > b.limit {
+		updated = updated[:b.limit]
+	}
+
+	b.logs[id] = updated
+	b.dirty[id] = true
+	return nil
+}
+
+// 変更のあった記録をバケットへ書き出し、しばらく参照されていない記録をメモリから取り除く
+func (b *bufferedLog[T]) flush() {
+	b.mu.Lock()
+	pending := make(map[string][]T, len(b.dirty))
+	for id := range b.dirty {
+		pending[id] = b.logs[id]
+	}
+	b.dirty = map[string]bool{}
+	b.mu.Unlock()
+
+	for id, items := range pending {
+		if err := saveUserData(b.prefix+id+".json", items); err != nil {
+			log.Printf("Failed to save %s%s: %v", b.prefix, id, err)
+			// 次回の書き出しで再試行
+			b.mu.Lock()
+			b.dirty[id] = true
+			b.mu.Unlock()
+		}
+	}
+
+	b.mu.Lock()
+	b.evictIdleLocked()
+	b.mu.Unlock()
+}
+
+// UserDataCacheTTL の間参照されていない記録をメモリから取り除く（未保存の記録は残す）
+// 呼び出し側でロック済みであること
+func (b *bufferedLog[T]) evictIdleLocked() {
+	for id, used := range b.used {
+		if !b.dirty[id] && time.Since(used) > UserDataCacheTTL {
+			delete(b.logs, id)
+			delete(b.used, id)
+		}
+	}
+}
+
+// 最近使ったファイル・アクティビティの未保存の変更を書き出す（終了時にも呼び出す）
+func FlushUserData() {
+	recentLog.flush()
+	activityLog.flush()
+}
+
+// 最近使ったファイル・アクティビティの定期書き出しを開始
+func StartUserDataFlusher() {
+	go func() {
+		ticker := time.NewTicker(UserDataFlushInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			FlushUserData()
+		}
+	}()
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBufferedLogEvictsIdleEntries(t *testing.T) {
+	idle := time.Now().Add(-UserDataCacheTTL - time.Minute)
+
+	b := newBufferedLog[int]("test/", 10)
+	b.logs["idle"], b.used["idle"] = []int{1}, idle
+	b.logs["active"], b.used["active"] = []int{2}, time.Now()
+	// 書き出しに失敗した記録は期限を過ぎても残す
+	b.logs["unsaved"], b.used["unsaved"], b.dirty["unsaved"] = []int{3}, idle, true
+
+	b.evictIdleLocked()
+
+	if _, ok := b.logs["idle"]; ok {
+		t.Error("idle entry was not evicted")
+	}
+	if _, ok := b.used["idle"]; ok {
+		t.Error("access time of the idle entry was not removed")
+	}
+	if _, ok := b.logs["active"]; !ok {
+		t.Error("recently used entry was evicted")
+	}
+	if _, ok := b.logs["unsaved"]; !ok {
+		t.Error("unsaved entry was evicted")
+	}
+}
+
+func TestBufferedLogFlushEvictsWithoutPendingChanges(t *testing.T) {
+	b := newBufferedLog[int]("test/", 10)
+	b.logs["idle"], b.used["idle"] = []int{1}, time.Now().Add(-UserDataCacheTTL-time.Minute)
+
+	// 変更がなければバケットへは書き出さず、期限切れの記録のみ取り除く
+	b.flush()
+
+	if len(b.logs) != 0 || len(b.used) != 0 {
+		t.Errorf("logs = %v, used = %v; want both empty", b.logs, b.used)
+	}
+}
@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+)
+
+var (
+	// バケットのバージョニングを有効にするか（MINIO_VERSIONING、既定false）
+	VersioningEnabled = envBool("MINIO_VERSIONING", false)
+	// 過去のバージョンを保持する日数（VERSION_RETENTION_DAYS、既定30日。0の場合は期限なし）
+	VersionRetentionDays = envInt64("VERSION_RETENTION_DAYS", 30)
+)
+
+// 過去のバージョンを期限後に削除するライフサイクルルールのID
+const versionLifecycleRuleID = "go-minio-noncurrent-expiration"
+
+// ファイルのバージョン情報
+type VersionInfo struct {
+	VersionID      string    `json:"versionId"`
+	Size           int64     `json:"size"`
+	LastModified   time.Time `json:"lastModified"`
+	ETag           string    `json:"etag"`
+	IsLatest       bool      `json:"isLatest"`
+	IsDeleteMarker bool      `json:"isDeleteMarker"`
+}
+
+// 設定に応じてバケットのバージョニングを有効化
+func enableVersioning() error {
+	if !VersioningEnabled {
+		return nil
+	}
+	ctx := context.Background()
+
+	config, err := client.GetBucketVersioning(ctx, bucketName)
+	if err != nil {
+		return err
+	}
+	if !config.Enabled() {
+		if err := client.EnableVersioning(ctx, bucketName); err != nil {
+			return err
+		}
+		log.Println("Bucket versioning enabled")
+	}
+	return applyVersionRetention(ctx)
+}
+
+// 過去のバージョンと不要になった削除マーカーを保持期間後に削除するライフサイクルルールを設定
+// 上書き・移動で残った過去のバージョンはこのルールで容量が解放される（他のルールはそのまま残す）
+func applyVersionRetention(ctx context.Context) error {
+	config, err := client.GetBucketLifecycle(ctx, bucketName)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code != "NoSuchLifecycleConfiguration" {
+			return err
+		}
+		config = lifecycle.NewConfiguration()
+	}
+
+	config.Rules = slices.DeleteFunc(config.Rules, func(rule lifecycle.Rule) bool {
+		return rule.ID == versionLifecycleRuleID
+	})
+	if VersionRetentionDays > 0 {
+		config.Rules = append(config.Rules, lifecycle.Rule{
+			ID:     versionLifecycleRuleID,
+			Status: "Enabled",
+			NoncurrentVersionExpiration: lifecycle.NoncurrentVersionExpiration{
+				NoncurrentDays: lifecycle.ExpirationDays(VersionRetentionDays),
+			},
+			Expiration: lifecycle.Expiration{DeleteMarker: true},
+		})
+	}
+	return client.SetBucketLifecycle(ctx, bucketName, config)
+}
+
+// キーの全バージョン（削除マーカーを含む）を取得
+func listKeyVersions(ctx context.Context, key string) ([]minio.ObjectInfo, error) {
+	var versions []minio.ObjectInfo
+	objectCh := client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Prefix:       key,
+		Recursive:    true,
+		WithVersions: true,
+		WithMetadata: true, // 過去のバージョンのサイズを取得するため
+	})
+	for object := range objectCh {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		// 同じプレフィックスを持つ別ファイルは除外
+		if object.Key == key {
+			versions = append(versions, object)
+		}
+	}
+	return versions, nil
+}
+
+// 過去のバージョン（削除マーカーを除く）の合計サイズ
+func noncurrentSize(versions []minio.ObjectInfo) int64 {
+	var total int64
+	for _, version := range versions {
+		if !version.IsLatest && !version.IsDeleteMarker {
+			total += versionSize(version)
+		}
+	}
+	return total
+}
+
+// 一覧で取得したバージョンのファイルのサイズ（ポインタ・圧縮・暗号化の場合は元のサイズ）
+func versionSize(version minio.ObjectInfo) int64 {
+	return logicalSize(minio.ObjectInfo{Size: version.Size, UserMetadata: listedMetadata(version.UserMetadata)})
+}
+
+// ファイルのバージョン履歴を取得（新しい順）
+func ListVersions(key string) ([]VersionInfo, error) {
+	versions := []VersionInfo{}
+
+	objectCh := client.ListObjects(context.Background(), bucketName, minio.ListObjectsOptions{
+		Prefix:       key,
+		Recursive:    true,
+		WithVersions: true,
+		WithMetadata: true, // 圧縮・暗号化されたバージョンのサイズを取得するため
+	})
+	for object := range objectCh {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		// 同じプレフィックスを持つ別ファイルは除外
+		if object.Key != key {
+			continue
+		}
+		// 重複排除のポインタは空のため、バージョンごとにファイルのサイズを取得する
+		if object.Size == 0 && !object.IsDeleteMarker {
+			if info, err := statObject(context.Background(), key, minio.StatObjectOptions{VersionID: object.VersionID}); err == nil {
+				object.Size = info.Size
+			}
+		} else {
+			object = logicalObject(object)
+		}
+		versions = append(versions, VersionInfo{
+			VersionID:      object.VersionID,
+			Size:           object.Size,
+			LastModified:   object.LastModified,
+			ETag:           object.ETag,
+			IsLatest:       object.IsLatest,
+			IsDeleteMarker: object.IsDeleteMarker,
+		})
+	}
+	if len(versions) == 0 {
+		return nil, ErrNotFound
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+	return versions, nil
+}
+
+// 指定バージョンのファイルを開く
+func OpenFileVersion(key, versionID string) (io.ReadSeekCloser, *FileInfo, error) {
+	return openObject(key, minio.GetObjectOptions{VersionID: versionID})
+}
+
+// 過去のバージョンを最新バージョンとして復元（サーバーサイドコピー）
+func RestoreVersion(key, versionID string) (*VersionInfo, error) {
+	ctx := context.Background()
+
+	srcInfo, srcSSE, err := statPhysical(ctx, key, minio.StatObjectOptions{VersionID: versionID})
+	if err != nil {
+		if isNoSuchObject(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	src := minio.CopySrcOptions{Bucket: bucketName, Object: key, VersionID: versionID, Encryption: copySourceSSE(srcSSE)}
+	dst := minio.CopyDestOptions{Bucket: bucketName, Object: key, Encryption: writeSSE(key)}
+	var info minio.UploadInfo
+	if srcInfo.Size > maxCopyObjectSize {
+		info, err = client.ComposeObject(ctx, dst, src)
+	} else {
+		info, err = client.CopyObject(ctx, dst, src)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 重複排除のポインタを復元した場合は参照先も引き継ぐ
+	hash, size, isPointer := pointerTarget(srcInfo.UserMetadata)
+	setBlobRef(key, hash, size)
+	if isPointer {
+		info.Size = size
+	}
+	indexObject(key, info.Size, info.LastModified, srcInfo.ContentType, nil, imageFromMetadata(srcInfo.UserMetadata))
+	modTime = time.Now()
+
+	return &VersionInfo{
+		VersionID:    info.VersionID,
+		Size:         info.Size,
+		LastModified: info.LastModified,
+		ETag:         info.ETag,
+		IsLatest:     true,
+	}, nil
+}
+
+// 特定のバージョンを完全に削除
+func DeleteVersion(key, versionID string) error {
+	err := client.RemoveObject(context.Background(), bucketName, key, minio.RemoveObjectOptions{VersionID: versionID})
+	if err != nil {
+		return err
+	}
+	// 最新バージョンを削除した場合は1つ前のバージョンが現在のファイルになる
+	refreshBlobRef(context.Background(), key)
+	modTime = time.Now()
+	return nil
+}